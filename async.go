@@ -0,0 +1,141 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpmw
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrLoggerClosed is returned by AsyncLogger.Log when the logger is closed.
+var ErrLoggerClosed = errors.New("httpmw: logger is closed")
+
+// DropPolicy defines what an AsyncLogger does when its queue is full.
+type DropPolicy int
+
+// List of supported drop policies.
+const (
+	// DropNewest drops the entry being logged.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest entry in the queue to make room for
+	// the entry being logged.
+	DropOldest
+	// Block waits until there is room in the queue. No entry is dropped,
+	// but a slow Logger slows down the callers.
+	Block
+)
+
+// DefaultQueueSize is the default size of the queue of an AsyncLogger.
+const DefaultQueueSize = 1024
+
+// AsyncLogger is a Logger that queues the entries and logs them to the
+// wrapped Logger in a separate goroutine, so that a slow Logger (e.g. on
+// a slow disk or a remote log sink) does not slow down the callers. It
+// is safe for concurrent use. Close must be called to flush the queue,
+// e.g. on graceful shutdown.
+type AsyncLogger struct {
+	// Logger is the wrapped logger. It is required.
+	Logger Logger
+
+	// QueueSize is the maximum number of entries in the queue. Defaults
+	// to DefaultQueueSize.
+	QueueSize int
+
+	// DropPolicy is the policy to apply when the queue is full. Defaults
+	// to DropNewest.
+	DropPolicy DropPolicy
+
+	once    sync.Once
+	mu      sync.RWMutex // protects closed and the sends on queue
+	closed  bool
+	queue   chan []interface{}
+	done    chan struct{}
+	dropped uint64
+}
+
+func (l *AsyncLogger) init() {
+	n := l.QueueSize
+	if n <= 0 {
+		n = DefaultQueueSize
+	}
+	l.queue = make(chan []interface{}, n)
+	l.done = make(chan struct{})
+	go l.run()
+}
+
+func (l *AsyncLogger) run() {
+	defer close(l.done)
+	for args := range l.queue {
+		l.Logger.Log(args...)
+	}
+}
+
+// Log implements Logger for the AsyncLogger. It queues the entry and
+// returns without waiting for it to be logged, unless the DropPolicy is
+// Block and the queue is full. It returns ErrLoggerClosed if the logger
+// is closed, in which case the entry is counted as dropped.
+func (l *AsyncLogger) Log(args ...interface{}) error {
+	l.once.Do(l.init)
+
+	// the caller may reuse its slice
+	entry := make([]interface{}, len(args))
+	copy(entry, args)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		atomic.AddUint64(&l.dropped, 1)
+		return ErrLoggerClosed
+	}
+
+	switch l.DropPolicy {
+	case Block:
+		l.queue <- entry
+
+	case DropOldest:
+		for {
+			select {
+			case l.queue <- entry:
+				return nil
+			default:
+			}
+			select {
+			case <-l.queue:
+				atomic.AddUint64(&l.dropped, 1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case l.queue <- entry:
+		default:
+			atomic.AddUint64(&l.dropped, 1)
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of entries dropped so far.
+func (l *AsyncLogger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close stops accepting entries and waits until the queued entries are
+// logged. It is safe to call it multiple times.
+func (l *AsyncLogger) Close() error {
+	l.once.Do(l.init)
+
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+
+	<-l.done
+	return nil
+}
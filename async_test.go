@@ -0,0 +1,86 @@
+package httpmw
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gateLogger records the entries, and blocks until the gate is closed.
+type gateLogger struct {
+	gate chan struct{}
+
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *gateLogger) Log(args ...interface{}) error {
+	<-l.gate
+	l.mu.Lock()
+	l.entries = append(l.entries, fmt.Sprint(args...))
+	l.mu.Unlock()
+	return nil
+}
+
+func TestAsyncLogger(t *testing.T) {
+	cases := []struct {
+		policy  DropPolicy
+		dropped uint64
+		want    []string
+	}{
+		{DropNewest, 3, []string{"0", "1", "2"}},
+		{DropOldest, 3, []string{"0", "4", "5"}},
+		{Block, 0, []string{"0", "1", "2", "3", "4", "5"}},
+	}
+	for i, c := range cases {
+		gl := &gateLogger{gate: make(chan struct{})}
+		l := &AsyncLogger{Logger: gl, QueueSize: 2, DropPolicy: c.policy}
+
+		// the first entry is dequeued and blocks the logger, then the queue
+		// is filled with the next 2 entries.
+		l.Log(0)
+		for len(l.queue) > 0 {
+			runtime.Gosched()
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j < 6; j++ {
+				assert.NoError(t, l.Log(j), "%d: log %d", i, j)
+			}
+		}()
+		if c.policy != Block {
+			wg.Wait()
+		}
+
+		close(gl.gate)
+		wg.Wait()
+		assert.NoError(t, l.Close(), "%d: close", i)
+
+		assert.Equal(t, c.dropped, l.Dropped(), "%d: dropped", i)
+		assert.Equal(t, c.want, gl.entries, "%d: entries", i)
+	}
+}
+
+func TestAsyncLoggerClose(t *testing.T) {
+	gl := &gateLogger{gate: make(chan struct{})}
+	close(gl.gate)
+	l := &AsyncLogger{Logger: gl}
+
+	for i := 0; i < 100; i++ {
+		l.Log(i)
+	}
+	assert.NoError(t, l.Close(), "close")
+	assert.Equal(t, 100, len(gl.entries), "flushed")
+	assert.Equal(t, ErrLoggerClosed, l.Log("x"), "log after close")
+	assert.Equal(t, uint64(1), l.Dropped(), "dropped after close")
+	assert.NoError(t, l.Close(), "close twice")
+
+	var empty AsyncLogger
+	assert.NoError(t, empty.Close(), "close unused")
+}
@@ -0,0 +1,243 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package canonicalhost implements a middleware that redirects requests
+// to a canonical scheme and host, and that sets the HTTP Strict Transport
+// Security (HSTS) header on secure responses. It is the scheme and host
+// counterpart of the cleanpath package.
+package canonicalhost
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw/remoteip"
+)
+
+// DefaultProtoHeaders is the list of headers inspected to get the scheme
+// used by the client when the request comes from a trusted proxy.
+var DefaultProtoHeaders = []string{"X-Forwarded-Proto"}
+
+// WWWMode specifies how the "www." prefix should be handled on the
+// request's host.
+type WWWMode int
+
+const (
+	// Leave keeps the host the way it was received.
+	Leave WWWMode = iota
+	// Add enforces the presence of the "www." prefix.
+	Add
+	// Remove enforces the removal of the "www." prefix.
+	Remove
+)
+
+// CanonicalHost holds the configuration for the middleware.
+type CanonicalHost struct {
+	// Host is the canonical host (and optional port) of the requests.
+	// If it is set, WWW is ignored and requests for any other host are
+	// redirected to this one.
+	Host string
+
+	// WWW specifies how the "www." prefix of the request's host should
+	// be handled. Defaults to Leave, which keeps it as it was received.
+	// It is ignored if Host is set.
+	WWW WWWMode
+
+	// ForceHTTPS redirects requests received over HTTP to HTTPS.
+	ForceHTTPS bool
+
+	// TrustedProxies is the list of IP addresses or CIDR ranges (e.g.
+	// "10.0.0.0/8") of the proxies allowed to set the ProtoHeaders. It is
+	// matched against the address of the peer connected to the server, so
+	// the remoteip middleware, which replaces that address with the
+	// client's, must not run before this one. Wrap panics if an entry is
+	// invalid.
+	TrustedProxies []string
+
+	// ProtoHeaders is the list of headers used to get the scheme of the
+	// client request when the request comes from a trusted proxy. If it
+	// is empty, DefaultProtoHeaders is used.
+	ProtoHeaders []string
+
+	// ExemptPaths is a list of path prefixes that are never redirected,
+	// e.g. "/.well-known/acme-challenge/".
+	ExemptPaths []string
+
+	// RedirectCode is the status code used to redirect GET and HEAD
+	// requests. Defaults to 301.
+	RedirectCode int
+
+	// NonGetRedirectCode is the status code used to redirect requests
+	// with any other method. Defaults to 308, which preserves the
+	// method and body.
+	NonGetRedirectCode int
+
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header.
+	// The header is only set on secure responses, and only if HSTSMaxAge
+	// is > 0.
+	HSTSMaxAge time.Duration
+
+	// HSTSIncludeSubDomains adds the includeSubDomains directive to the
+	// Strict-Transport-Security header.
+	HSTSIncludeSubDomains bool
+
+	// HSTSPreload adds the preload directive to the Strict-Transport-Security
+	// header.
+	HSTSPreload bool
+}
+
+// Wrap returns a handler that redirects to the canonical scheme and host
+// if the request doesn't use them. Requests for the ExemptPaths are never
+// redirected. Secure responses get the Strict-Transport-Security header
+// if HSTSMaxAge is set.
+//
+// If the request is already for the canonical scheme and host, it calls
+// the handler h. If the request must be redirected but it has no host and
+// Host is not set, it returns a status code 400.
+func (ch *CanonicalHost) Wrap(h http.Handler) http.Handler {
+	proxies, err := remoteip.ParseNets(ch.TrustedProxies)
+	if err != nil {
+		panic("canonicalhost: " + err.Error())
+	}
+	protoHeaders := ch.ProtoHeaders
+	if len(protoHeaders) == 0 {
+		protoHeaders = DefaultProtoHeaders
+	}
+	getCode := ch.RedirectCode
+	if getCode == 0 {
+		getCode = http.StatusMovedPermanently
+	}
+	otherCode := ch.NonGetRedirectCode
+	if otherCode == 0 {
+		otherCode = http.StatusPermanentRedirect
+	}
+
+	var hsts string
+	if ch.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(ch.HSTSMaxAge.Seconds()), 10)
+		if ch.HSTSIncludeSubDomains {
+			hsts += "; includeSubDomains"
+		}
+		if ch.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secure := isSecure(r, proxies, protoHeaders)
+		if secure && hsts != "" {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+
+		if r.Method == "CONNECT" || isExempt(r.URL.Path, ch.ExemptPaths) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		scheme := "http"
+		if secure || ch.ForceHTTPS {
+			scheme = "https"
+		}
+		host := canonicalHost(r.Host, ch.Host, ch.WWW)
+		if !secure && scheme == "https" {
+			host = trimPort(host, "80")
+		}
+
+		if (scheme == "https") == secure && host == r.Host {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if host == "" {
+			// without a host, the path would be taken as the host of the
+			// redirect URL (e.g. "https:///evil.com/").
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		u := url.URL{
+			Scheme:   scheme,
+			Host:     host,
+			Path:     r.URL.Path,
+			RawPath:  r.URL.RawPath,
+			RawQuery: r.URL.RawQuery,
+		}
+		code := otherCode
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "" {
+			code = getCode
+		}
+		http.Redirect(w, r, u.String(), code)
+	})
+}
+
+// canonicalHost returns the canonical host for the request host reqHost.
+func canonicalHost(reqHost, host string, mode WWWMode) string {
+	if host != "" {
+		return host
+	}
+	if reqHost == "" {
+		return reqHost
+	}
+
+	const www = "www."
+	switch mode {
+	case Add:
+		if !strings.HasPrefix(strings.ToLower(reqHost), www) {
+			return www + reqHost
+		}
+	case Remove:
+		if strings.HasPrefix(strings.ToLower(reqHost), www) {
+			return reqHost[len(www):]
+		}
+	}
+	return reqHost
+}
+
+// trimPort removes the port from host if it is port.
+func trimPort(host, port string) string {
+	if h, p, err := net.SplitHostPort(host); err == nil && p == port {
+		if strings.Contains(h, ":") {
+			// IPv6 address
+			return "[" + h + "]"
+		}
+		return h
+	}
+	return host
+}
+
+func isExempt(path string, prefixes []string) bool {
+	for _, pfx := range prefixes {
+		if strings.HasPrefix(path, pfx) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecure returns true if the client request was made over HTTPS,
+// either directly or, if the request comes from a trusted proxy, as
+// indicated by the proto headers.
+func isSecure(r *http.Request, proxies remoteip.Nets, headers []string) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !proxies.Contains(r.RemoteAddr) {
+		return false
+	}
+	for _, key := range headers {
+		if vals := r.Header[http.CanonicalHeaderKey(key)]; len(vals) > 0 {
+			// use the last value, which is the one set by the trusted proxy,
+			// the previous ones may have been set by the client.
+			v := vals[len(vals)-1]
+			if i := strings.LastIndex(v, ","); i >= 0 {
+				v = v[i+1:]
+			}
+			return strings.EqualFold(strings.TrimSpace(v), "https")
+		}
+	}
+	return false
+}
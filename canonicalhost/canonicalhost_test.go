@@ -0,0 +1,109 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package canonicalhost
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	cases := []struct {
+		ch     CanonicalHost
+		method string
+		url    string
+		tls    bool
+		remote string
+		proto  string
+		code   int
+		loc    string
+	}{
+		{CanonicalHost{}, "GET", "http://example.com/a", false, "", "", 200, ""},
+		{CanonicalHost{WWW: Add}, "GET", "http://example.com/a?b", false, "", "", 301, "http://www.example.com/a?b"},
+		{CanonicalHost{WWW: Add}, "GET", "http://www.example.com/a", false, "", "", 200, ""},
+		{CanonicalHost{WWW: Remove}, "GET", "http://WWW.example.com/a", false, "", "", 301, "http://example.com/a"},
+		{CanonicalHost{WWW: Remove}, "GET", "http://example.com/a", false, "", "", 200, ""},
+		{CanonicalHost{Host: "example.com"}, "GET", "http://other.com/a", false, "", "", 301, "http://example.com/a"},
+		{CanonicalHost{Host: "example.com"}, "POST", "http://other.com/a", false, "", "", 308, "http://example.com/a"},
+		{CanonicalHost{Host: "example.com", RedirectCode: 302}, "GET", "http://other.com/a", false, "", "", 302, "http://example.com/a"},
+		{CanonicalHost{ForceHTTPS: true}, "GET", "http://example.com/a%2Fb", false, "", "", 301, "https://example.com/a%2Fb"},
+		{CanonicalHost{ForceHTTPS: true}, "GET", "http://example.com:80/a", false, "", "", 301, "https://example.com/a"},
+		{CanonicalHost{ForceHTTPS: true}, "GET", "http://example.com:8080/a", false, "", "", 301, "https://example.com:8080/a"},
+		{CanonicalHost{ForceHTTPS: true}, "GET", "https://example.com/a", true, "", "", 200, ""},
+		{CanonicalHost{ForceHTTPS: true, WWW: Add}, "GET", "https://example.com/a", true, "", "", 301, "https://www.example.com/a"},
+		// no host, e.g. HTTP/1.0
+		{CanonicalHost{ForceHTTPS: true}, "GET", "/evil.com/a", false, "", "", 400, ""},
+		{CanonicalHost{ForceHTTPS: true, Host: "example.com"}, "GET", "/evil.com/a", false, "", "", 301, "https://example.com/evil.com/a"},
+		{CanonicalHost{WWW: Add}, "GET", "/a", false, "", "", 200, ""},
+
+		// untrusted proxy
+		{CanonicalHost{ForceHTTPS: true}, "GET", "http://example.com/a", false, "1.2.3.4:1000", "https", 301, "https://example.com/a"},
+		{CanonicalHost{ForceHTTPS: true, TrustedProxies: []string{"10.0.0.0/8"}}, "GET", "http://example.com/a", false, "1.2.3.4:1000", "https", 301, "https://example.com/a"},
+		// trusted proxy
+		{CanonicalHost{ForceHTTPS: true, TrustedProxies: []string{"10.0.0.0/8"}}, "GET", "http://example.com/a", false, "10.1.2.3:1000", "https", 200, ""},
+		{CanonicalHost{ForceHTTPS: true, TrustedProxies: []string{"10.1.2.3"}}, "GET", "http://example.com/a", false, "10.1.2.3", "https, http", 301, "https://example.com/a"},
+		{CanonicalHost{ForceHTTPS: true, TrustedProxies: []string{"10.1.2.3"}}, "GET", "http://example.com/a", false, "10.1.2.3", "http, https", 200, ""},
+		{CanonicalHost{ForceHTTPS: true, TrustedProxies: []string{"10.1.2.3"}}, "GET", "http://example.com/a", false, "10.1.2.3:1000", "http", 301, "https://example.com/a"},
+
+		// exempt paths
+		{CanonicalHost{ForceHTTPS: true, ExemptPaths: []string{"/.well-known/acme-challenge/"}}, "GET", "http://example.com/.well-known/acme-challenge/x", false, "", "", 200, ""},
+		{CanonicalHost{ForceHTTPS: true, ExemptPaths: []string{"/.well-known/acme-challenge/"}}, "GET", "http://example.com/.well-known/x", false, "", "", 301, "https://example.com/.well-known/x"},
+	}
+	for i, c := range cases {
+		h := httpmw.Wrap(httpmw.StatusHandler(200), &c.ch)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(c.method, c.url, nil)
+		if c.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if c.remote != "" {
+			r.RemoteAddr = c.remote
+		}
+		if c.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", c.proto)
+		}
+
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.code, w.Code, "%d: status", i)
+		assert.Equal(t, c.loc, w.Header().Get("Location"), "%d: location", i)
+	}
+}
+
+func TestCanonicalHostHSTS(t *testing.T) {
+	cases := []struct {
+		ch   CanonicalHost
+		tls  bool
+		want string
+	}{
+		{CanonicalHost{}, true, ""},
+		{CanonicalHost{HSTSMaxAge: time.Hour}, false, ""},
+		{CanonicalHost{HSTSMaxAge: time.Hour}, true, "max-age=3600"},
+		{CanonicalHost{HSTSMaxAge: time.Hour, HSTSIncludeSubDomains: true}, true, "max-age=3600; includeSubDomains"},
+		{CanonicalHost{HSTSMaxAge: time.Hour, HSTSIncludeSubDomains: true, HSTSPreload: true}, true, "max-age=3600; includeSubDomains; preload"},
+	}
+	for i, c := range cases {
+		h := httpmw.Wrap(httpmw.StatusHandler(200), &c.ch)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "https://example.com/", nil)
+		if c.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+
+		h.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code, "%d: status", i)
+		assert.Equal(t, c.want, w.Header().Get("Strict-Transport-Security"), "%d: hsts", i)
+	}
+}
+
+func TestCanonicalHostInvalidProxy(t *testing.T) {
+	ch := &CanonicalHost{TrustedProxies: []string{"10.0.0.0/8", "nope"}}
+	assert.Panics(t, func() { ch.Wrap(httpmw.StatusHandler(200)) }, "invalid proxy")
+}
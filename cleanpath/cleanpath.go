@@ -4,15 +4,22 @@
 
 // Package cleanpath implements a middleware that cleans the requested
 // path to a canonical form. It redirects to the clean path if the
-// requested path is not the canonical one. Some request multiplexers
+// requested path is not the canonical one, or optionally rewrites the
+// request's path in place. Some request multiplexers
 // already do this automatically to some extent (e.g. http.ServeMux cleans
 // the . and .. parts of the path, others handle the trailing slash),
 // but this middleware handles this uniformly regardless of the mux used.
+//
+// The path is cleaned in its escaped form, so that encoded slashes (%2F)
+// are preserved and never treated as path separators. Additional
+// normalizations (lowercasing, percent-encoding normalization, removal
+// of an empty query string) can be enabled in the configuration.
 package cleanpath
 
 import (
 	"net/http"
-	"path"
+	"net/url"
+	"strings"
 )
 
 // TrailingSlashMode specifies how the trailing slash should be
@@ -35,22 +42,94 @@ type CleanPath struct {
 	// The mode does not apply to the root slash, which is always present
 	// if the path is otherwise empty.
 	TrailingSlash TrailingSlashMode
+
+	// RedirectCode is the status code used to redirect GET and HEAD
+	// requests to the canonical path. It should be one of 301, 302,
+	// 307 or 308. Defaults to 301.
+	RedirectCode int
+
+	// NonGetRedirectCode is the status code used to redirect requests
+	// with any other method to the canonical path. Most clients change
+	// the method to GET and drop the body when following a 301 or 302,
+	// so the default is 308, which preserves both.
+	NonGetRedirectCode int
+
+	// Rewrite indicates that the request's path should be cleaned in
+	// place instead of redirecting to the canonical path. The handler
+	// is then called with the updated request, and the client never
+	// sees the canonical path.
+	Rewrite bool
+
+	// LowercasePath converts the path to lowercase. Percent-encoded
+	// octets are not affected.
+	LowercasePath bool
+
+	// NormalizeEncoding normalizes the percent-encoding of the path as
+	// described in RFC 3986: the hexadecimal digits are uppercased and
+	// the unreserved characters (letters, digits, "-", ".", "_" and "~")
+	// are decoded.
+	NormalizeEncoding bool
+
+	// KeepDuplicateSlashes disables the collapsing of consecutive
+	// slashes into a single one. Encoded slashes (%2F) are never
+	// collapsed, and leading slashes are always collapsed.
+	KeepDuplicateSlashes bool
+
+	// KeepDotSegments disables the removal of the . and .. segments
+	// of the path.
+	KeepDotSegments bool
+
+	// RemoveEmptyQuery removes the query separator "?" if it is not
+	// followed by a query string.
+	RemoveEmptyQuery bool
 }
 
-// Wrap returns a handler that redirects with a status code 301 to the
-// canonical path if the requested path is not as expected. It cleans
-// the . and .. parts and handles the trailing slash according to the
-// middleware configuration.
+// Wrap returns a handler that redirects to the canonical path if the
+// requested path is not as expected. By default it cleans the . and ..
+// parts and the duplicate slashes, and handles the trailing slash
+// according to the middleware configuration. If Rewrite is true, the
+// request's URL is set to the canonical one and the handler h is called
+// instead of redirecting.
 //
 // If the path is already in a canonical form, it calls the handler h.
 func (cp *CleanPath) Wrap(h http.Handler) http.Handler {
-	mode := cp.TrailingSlash
+	opts := *cp
+	if opts.RedirectCode == 0 {
+		opts.RedirectCode = http.StatusMovedPermanently
+	}
+	if opts.NonGetRedirectCode == 0 {
+		opts.NonGetRedirectCode = http.StatusPermanentRedirect
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "CONNECT" {
-			if p := cleanPath(r.URL.Path, mode); p != r.URL.Path {
-				url := *r.URL
-				url.Path = p
-				http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
+			raw := r.URL.EscapedPath()
+			p := cleanPath(raw, &opts)
+			emptyQuery := opts.RemoveEmptyQuery && r.URL.ForceQuery && r.URL.RawQuery == ""
+			if p != raw || emptyQuery {
+				u := *r.URL
+				if err := setRawPath(&u, p); err != nil {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				if emptyQuery {
+					u.ForceQuery = false
+				}
+
+				if opts.Rewrite {
+					*r.URL = u
+					h.ServeHTTP(w, r)
+					return
+				}
+
+				code := opts.NonGetRedirectCode
+				if r.Method == "GET" || r.Method == "HEAD" || r.Method == "" {
+					code = opts.RedirectCode
+				}
+				// http.Redirect cleans the path, which would defeat the
+				// KeepDuplicateSlashes and KeepDotSegments options.
+				w.Header().Set("Location", u.String())
+				w.WriteHeader(code)
 				return
 			}
 		}
@@ -58,27 +137,39 @@ func (cp *CleanPath) Wrap(h http.Handler) http.Handler {
 	})
 }
 
-// cleanPath comes from https://golang.org/src/net/http/server.go,
-// adapted to support the trailing slash mode.
-//
-// Copyright 2009 The Go Authors. All rights reserved.
-// Use of this source code is governed by a BSD-style
-// license that can be found in the LICENSE file.
-func cleanPath(p string, mode TrailingSlashMode) string {
+// setRawPath sets the escaped path p on u, updating the Path field and
+// the RawPath field if p is not the default encoding of the path.
+func setRawPath(u *url.URL, p string) error {
+	up, err := url.PathUnescape(p)
+	if err != nil {
+		return err
+	}
+	u.Path = up
+	u.RawPath = ""
+	if u.EscapedPath() != p {
+		u.RawPath = p
+	}
+	return nil
+}
+
+// cleanPath returns the canonical form of the escaped path p, as
+// configured by opts.
+func cleanPath(p string, opts *CleanPath) string {
 	if p == "" {
 		return "/"
 	}
 	if p[0] != '/' {
 		p = "/" + p
 	}
-	np := path.Clean(p)
-	// path.Clean removes trailing slash except for root;
-	// put the trailing slash back if necessary.
-	if p[len(p)-1] == '/' && np != "/" {
-		np += "/"
+	if opts.NormalizeEncoding {
+		p = normalizeEncoding(p)
+	}
+	if opts.LowercasePath {
+		p = lowercase(p)
 	}
+	np := cleanSegments(p, !opts.KeepDuplicateSlashes, !opts.KeepDotSegments)
 
-	switch mode {
+	switch opts.TrailingSlash {
 	case Add:
 		if np[len(np)-1] != '/' {
 			np += "/"
@@ -90,3 +181,108 @@ func cleanPath(p string, mode TrailingSlashMode) string {
 	}
 	return np
 }
+
+// cleanSegments collapses the empty segments if collapse is true and
+// removes the dot-segments if dots is true. The escaped path p must
+// start with a slash. As with path.Clean, a trailing slash is kept
+// only if p had one.
+func cleanSegments(p string, collapse, dots bool) string {
+	segs := strings.Split(p[1:], "/")
+	out := make([]string, 0, len(segs))
+	for i, seg := range segs {
+		switch {
+		case dots && isDotSegment(seg, "."):
+		case dots && isDotSegment(seg, ".."):
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case seg == "" && (collapse || len(out) == 0 || i == len(segs)-1):
+			// leading empty segments are always removed, otherwise the
+			// path could be mistaken for a scheme-relative URL.
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	np := "/" + strings.Join(out, "/")
+	if p[len(p)-1] == '/' && len(out) > 0 {
+		np += "/"
+	}
+	return np
+}
+
+// isDotSegment returns true if the escaped segment seg is the dot
+// segment dot, possibly with its dots percent-encoded.
+func isDotSegment(seg, dot string) bool {
+	if seg == dot {
+		return true
+	}
+	if len(seg) == len(dot) || !strings.Contains(seg, "%") {
+		return false
+	}
+	useg, err := url.PathUnescape(seg)
+	return err == nil && useg == dot
+}
+
+// lowercase returns the escaped path p with all characters lowercased,
+// except for the percent-encoded octets.
+func lowercase(p string) string {
+	b := []byte(p)
+	for i := 0; i < len(b); i++ {
+		if b[i] == '%' {
+			i += 2
+			continue
+		}
+		if 'A' <= b[i] && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// normalizeEncoding returns the escaped path p with uppercase
+// hexadecimal digits in percent-encoded octets and with the unreserved
+// characters decoded.
+func normalizeEncoding(p string) string {
+	if !strings.Contains(p, "%") {
+		return p
+	}
+
+	const hex = "0123456789ABCDEF"
+	b := make([]byte, 0, len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] != '%' || i+2 >= len(p) {
+			b = append(b, p[i])
+			continue
+		}
+		hi, lo := unhex(p[i+1]), unhex(p[i+2])
+		if hi < 0 || lo < 0 {
+			b = append(b, p[i])
+			continue
+		}
+		if c := byte(hi<<4 | lo); isUnreserved(c) {
+			b = append(b, c)
+		} else {
+			b = append(b, '%', hex[hi], hex[lo])
+		}
+		i += 2
+	}
+	return string(b)
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -59,3 +59,124 @@ func TestCleanPath(t *testing.T) {
 		assert.Equal(t, c.newPath, w.Header().Get("Location"), "%d: location", i)
 	}
 }
+
+func TestCleanPathRedirectCode(t *testing.T) {
+	cases := []struct {
+		method   string
+		code     int
+		nonGet   int
+		wantCode int
+	}{
+		{"GET", 0, 0, 301},
+		{"HEAD", 0, 0, 301},
+		{"POST", 0, 0, 308},
+		{"PUT", 0, 0, 308},
+		{"GET", 302, 307, 302},
+		{"DELETE", 302, 307, 307},
+		{"GET", 308, 0, 308},
+	}
+	for i, c := range cases {
+		cp := &CleanPath{RedirectCode: c.code, NonGetRedirectCode: c.nonGet}
+		h := httpmw.Wrap(httpmw.StatusHandler(200), cp)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(c.method, "/a//b/", nil)
+
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.wantCode, w.Code, "%d: status", i)
+		assert.Equal(t, "/a/b/", w.Header().Get("Location"), "%d: location", i)
+	}
+}
+
+func TestCleanPathRewrite(t *testing.T) {
+	cases := []struct {
+		path string
+		mode TrailingSlashMode
+		want string
+	}{
+		{"/", Leave, "/"},
+		{"a/b", Leave, "/a/b"},
+		{"/a/b/./..//", Remove, "/a"},
+		{"/api//items/", Add, "/api/items/"},
+	}
+	for i, c := range cases {
+		var got string
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.Path
+			w.WriteHeader(200)
+		})
+		cp := &CleanPath{TrailingSlash: c.mode, Rewrite: true}
+		h := httpmw.Wrap(fn, cp)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", c.path, nil)
+
+		h.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code, "%d: status", i)
+		assert.Equal(t, "", w.Header().Get("Location"), "%d: location", i)
+		assert.Equal(t, c.want, got, "%d: path", i)
+	}
+}
+
+func TestCleanPathNormalize(t *testing.T) {
+	cases := []struct {
+		uri  string
+		cp   CleanPath
+		want string
+	}{
+		{"/a%2Fb", CleanPath{}, ""},
+		{"/a%2F%2Fb", CleanPath{}, ""},
+		{"/a%2F..%2Fb", CleanPath{}, ""},
+		{"/a//b%2F/./c", CleanPath{}, "/a/b%2F/c"},
+		{"/a/%2e%2E/b", CleanPath{}, "/b"},
+		{"/a//b", CleanPath{KeepDuplicateSlashes: true}, ""},
+		{"/a//b/../c", CleanPath{KeepDuplicateSlashes: true}, "/a//c"},
+		{"/x/..//a//b/..", CleanPath{KeepDuplicateSlashes: true}, "/a/"},
+		{"/a/./b", CleanPath{KeepDotSegments: true}, ""},
+		{"/a/.//b", CleanPath{KeepDotSegments: true}, "/a/./b"},
+		{"/A/b%2f", CleanPath{LowercasePath: true}, "/a/b%2f"},
+		{"/a/b%2fC", CleanPath{LowercasePath: true}, "/a/b%2fc"},
+		{"/a/b%2f", CleanPath{NormalizeEncoding: true}, "/a/b%2F"},
+		{"/%7Euser/%41%2d%5f", CleanPath{NormalizeEncoding: true}, "/~user/A-_"},
+		{"/%7Euser/%41", CleanPath{NormalizeEncoding: true, LowercasePath: true}, "/~user/a"},
+		{"/a/%2E/b", CleanPath{NormalizeEncoding: true, KeepDotSegments: true}, "/a/./b"},
+		{"/a?", CleanPath{}, ""},
+		{"/a?", CleanPath{RemoveEmptyQuery: true}, "/a"},
+		{"/a?b=c", CleanPath{RemoveEmptyQuery: true}, ""},
+		{"/a//?", CleanPath{RemoveEmptyQuery: true}, "/a/"},
+		{"/a//?b", CleanPath{RemoveEmptyQuery: true}, "/a/?b"},
+	}
+	for i, c := range cases {
+		var got string
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.String()
+			w.WriteHeader(200)
+		})
+
+		// redirect mode
+		cp := c.cp
+		h := httpmw.Wrap(fn, &cp)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", c.uri, nil)
+		h.ServeHTTP(w, r)
+		if c.want == "" {
+			assert.Equal(t, 200, w.Code, "%d: status", i)
+			assert.Equal(t, c.uri, got, "%d: handler URL", i)
+		} else {
+			assert.Equal(t, 301, w.Code, "%d: status", i)
+			assert.Equal(t, c.want, w.Header().Get("Location"), "%d: location", i)
+		}
+
+		// rewrite mode
+		got = ""
+		cp.Rewrite = true
+		h = httpmw.Wrap(fn, &cp)
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("", c.uri, nil)
+		h.ServeHTTP(w, r)
+		want := c.want
+		if want == "" {
+			want = c.uri
+		}
+		assert.Equal(t, 200, w.Code, "%d: rewrite status", i)
+		assert.Equal(t, want, got, "%d: rewrite URL", i)
+	}
+}
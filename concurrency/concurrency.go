@@ -0,0 +1,195 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package concurrency implements a middleware that limits the number of
+// requests executing at the same time, queuing the excess requests.
+package concurrency
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Order is the order in which the queued requests are executed.
+type Order int
+
+// List of supported orders.
+const (
+	// FIFO executes the oldest queued request first.
+	FIFO Order = iota
+	// LIFO executes the newest queued request first. Under sustained
+	// overload, it serves the requests whose clients are more likely to
+	// still be waiting for the response.
+	LIFO
+)
+
+// DefaultRetryAfter is the default value of the Retry-After header of
+// the rejected requests.
+const DefaultRetryAfter = time.Second
+
+// Concurrency holds the configuration for the concurrency middleware.
+type Concurrency struct {
+	// MaxInFlight is the maximum number of requests executing at the same
+	// time (per key if KeyFunc is set). It must be > 0.
+	MaxInFlight int
+
+	// KeyFunc returns the key of the request, e.g. the client's IP
+	// address. Each key has its own limit of MaxInFlight requests. If it
+	// is nil, the limit is shared by all requests.
+	KeyFunc func(r *http.Request) string
+
+	// MaxQueue is the maximum number of requests waiting for a request to
+	// complete (per key if KeyFunc is set). A request received when the
+	// queue is full is rejected. If it is <= 0, requests are never queued.
+	MaxQueue int
+
+	// QueueTimeout is the maximum time a request waits in the queue,
+	// after which it is rejected. If it is <= 0, the request waits until
+	// it can execute or it is canceled.
+	QueueTimeout time.Duration
+
+	// Order is the order in which the queued requests are executed.
+	// Defaults to FIFO.
+	Order Order
+
+	// RetryAfter is the value of the Retry-After header of the rejected
+	// requests, rounded up to the second. Defaults to DefaultRetryAfter.
+	RetryAfter time.Duration
+}
+
+// Wrap returns a handler that calls h only if fewer than MaxInFlight
+// requests are executing, otherwise it queues the request until one
+// completes. If the queue is full or the request waits longer than
+// QueueTimeout, it returns a status code 503 with a Retry-After header.
+//
+// Each call to Wrap creates a new, distinct limiter that controls access
+// to h. It panics if MaxInFlight is <= 0.
+func (c *Concurrency) Wrap(h http.Handler) http.Handler {
+	if c.MaxInFlight <= 0 {
+		panic("concurrency: MaxInFlight must be > 0")
+	}
+	retry := c.RetryAfter
+	if retry <= 0 {
+		retry = DefaultRetryAfter
+	}
+	retryAfter := strconv.FormatInt(int64((retry+time.Second-1)/time.Second), 10)
+
+	lim := &limiter{
+		max:   c.MaxInFlight,
+		queue: c.MaxQueue,
+		lifo:  c.Order == LIFO,
+		keys:  make(map[string]*slots),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		if c.KeyFunc != nil {
+			key = c.KeyFunc(r)
+		}
+
+		if !lim.acquire(r, key, c.QueueTimeout) {
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer lim.release(key)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limiter limits the number of requests in flight per key.
+type limiter struct {
+	max   int
+	queue int
+	lifo  bool
+
+	mu   sync.Mutex
+	keys map[string]*slots // removed when unused
+}
+
+// slots holds the state of a key.
+type slots struct {
+	inFlight int
+	waiters  *list.List // of chan struct{}, in order of arrival
+}
+
+// acquire returns true when the request r of the key can execute, or
+// false if it is rejected.
+func (l *limiter) acquire(r *http.Request, key string, timeout time.Duration) bool {
+	l.mu.Lock()
+	s := l.keys[key]
+	if s == nil {
+		s = &slots{waiters: list.New()}
+		l.keys[key] = s
+	}
+	if s.inFlight < l.max {
+		s.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if s.waiters.Len() >= l.queue {
+		l.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{}, 1)
+	el := s.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	select {
+	case <-ready:
+		return true
+	case <-expired:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// the slot was handed over concurrently, give it back
+		l.releaseLocked(key, s)
+	default:
+		s.waiters.Remove(el)
+		l.cleanupLocked(key, s)
+	}
+	return false
+}
+
+// release releases the slot of a request of the key, handing it over to
+// a queued request if there is one.
+func (l *limiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(key, l.keys[key])
+}
+
+func (l *limiter) releaseLocked(key string, s *slots) {
+	if s.waiters.Len() > 0 {
+		el := s.waiters.Front()
+		if l.lifo {
+			el = s.waiters.Back()
+		}
+		s.waiters.Remove(el)
+		el.Value.(chan struct{}) <- struct{}{}
+		return
+	}
+	s.inFlight--
+	l.cleanupLocked(key, s)
+}
+
+func (l *limiter) cleanupLocked(key string, s *slots) {
+	if s.inFlight == 0 && s.waiters.Len() == 0 {
+		delete(l.keys, key)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package concurrency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler returns a handler that blocks until release is closed,
+// and a channel that receives a value when a request starts.
+func blockingHandler(release chan struct{}) (http.Handler, chan string) {
+	started := make(chan string, 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- r.URL.Path
+		<-release
+	}), started
+}
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", path, nil)
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestConcurrencyReject(t *testing.T) {
+	cases := []struct {
+		c     Concurrency
+		retry string
+	}{
+		{Concurrency{MaxInFlight: 1}, "1"},
+		{Concurrency{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond, RetryAfter: 1500 * time.Millisecond}, "2"},
+	}
+	for i, c := range cases {
+		release := make(chan struct{})
+		fn, started := blockingHandler(release)
+		h := httpmw.Wrap(fn, &c.c)
+
+		done := make(chan int)
+		go func() { done <- serve(h, "/a").Code }()
+		<-started
+
+		w := serve(h, "/b")
+		assert.Equal(t, 503, w.Code, "%d: status", i)
+		assert.Equal(t, c.retry, w.Header().Get("Retry-After"), "%d: retry after", i)
+
+		close(release)
+		assert.Equal(t, 200, <-done, "%d: first status", i)
+		assert.Equal(t, 200, serve(h, "/c").Code, "%d: after release", i)
+	}
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	release := make(chan struct{})
+	fn, started := blockingHandler(release)
+	h := httpmw.Wrap(fn, &Concurrency{MaxInFlight: 2, MaxQueue: 2})
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for _, p := range []string{"/1", "/2", "/3", "/4", "/5"} {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			codes <- serve(h, p).Code
+		}(p)
+	}
+
+	// 2 in flight, 2 queued and 1 rejected
+	<-started
+	<-started
+	assert.Equal(t, 503, <-codes, "rejected")
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		assert.Equal(t, 200, code, "queued")
+	}
+}
+
+func TestConcurrencyKey(t *testing.T) {
+	release := make(chan struct{})
+	fn, started := blockingHandler(release)
+	h := httpmw.Wrap(fn, &Concurrency{
+		MaxInFlight: 1,
+		KeyFunc:     func(r *http.Request) string { return r.URL.Path },
+	})
+
+	done := make(chan int)
+	go func() { done <- serve(h, "/a").Code }()
+	<-started
+	assert.Equal(t, 503, serve(h, "/a").Code, "same key")
+
+	go func() { done <- serve(h, "/b").Code }()
+	<-started
+	close(release)
+	assert.Equal(t, 200, <-done, "first key")
+	assert.Equal(t, 200, <-done, "other key")
+}
+
+func TestLimiterOrder(t *testing.T) {
+	for _, lifo := range []bool{false, true} {
+		l := &limiter{max: 1, queue: 3, lifo: lifo, keys: make(map[string]*slots)}
+		r, _ := http.NewRequest("", "/", nil)
+		assert.True(t, l.acquire(r, "", 0), "%t: first", lifo)
+
+		order := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			go func(i int) {
+				if l.acquire(r, "", 0) {
+					order <- i
+					l.release("")
+				}
+			}(i)
+			waitQueued(l, i+1)
+		}
+
+		l.release("")
+		want := []int{0, 1, 2}
+		if lifo {
+			want = []int{2, 1, 0}
+		}
+		got := []int{<-order, <-order, <-order}
+		assert.Equal(t, want, got, "%t: order", lifo)
+
+		l.mu.Lock()
+		assert.Equal(t, 0, len(l.keys), "%t: keys removed", lifo)
+		l.mu.Unlock()
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := &limiter{max: 1, queue: 1, keys: make(map[string]*slots)}
+	r, _ := http.NewRequest("", "/", nil)
+	assert.True(t, l.acquire(r, "", 0), "first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- l.acquire(r.WithContext(ctx), "", 0) }()
+	waitQueued(l, 1)
+	cancel()
+	assert.False(t, <-done, "canceled")
+
+	l.release("")
+	l.mu.Lock()
+	assert.Equal(t, 0, len(l.keys), "keys removed")
+	l.mu.Unlock()
+}
+
+// waitQueued waits until n requests are queued for the empty key.
+func waitQueued(l *limiter, n int) {
+	for {
+		l.mu.Lock()
+		s := l.keys[""]
+		queued := s != nil && s.waiters.Len() == n
+		l.mu.Unlock()
+		if queued {
+			return
+		}
+		runtime.Gosched()
+	}
+}
@@ -3,7 +3,9 @@
 // license that can be found in the LICENSE file.
 
 // Package cors implements a CORS middleware and a handler
-// for OPTIONS requests.
+// for OPTIONS requests. A single CORS configuration applies to all
+// requests, while a PolicySet applies a distinct configuration per
+// route.
 package cors
 
 import (
@@ -11,19 +13,31 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // CORS holds the configuration for the cors middleware.
 type CORS struct {
 	// AllowCredentials indicates if the request is allowed to send
-	// credentials information along with the request.
+	// credentials information along with the request. It cannot be
+	// used with the "*" origin (see Validate).
 	AllowCredentials bool
 
+	// AllowPrivateNetwork indicates if preflight requests for the Private
+	// Network Access specification are allowed, that is, requests from a
+	// public website to a server on a private network. It sets the
+	// Access-Control-Allow-Private-Network header on preflight responses
+	// that have the Access-Control-Request-Private-Network header.
+	AllowPrivateNetwork bool
+
 	// MaxAge sets the time to cache the OPTIONS response.
 	MaxAge time.Duration
 
-	// AllowHeaders is list of headers allowed by the CORS endpoint.
+	// AllowHeaders is list of headers allowed by the CORS endpoint. The
+	// headers requested by a preflight request are compared to this list
+	// case-insensitively. The special value "*" can be used to allow any
+	// header.
 	AllowHeaders []string
 
 	// ExposeHeaders is a list of headers exposed to the client by the CORS endpoint.
@@ -32,7 +46,16 @@ type CORS struct {
 	// AllowOrigins is a list of whitelisted, allowed origins. It should
 	// include the scheme and port, as required. The value is lowercased
 	// and compared to the Origin header received from the client. The
-	// special value "*" can be used to allow any origin.
+	// special value "*" can be used to allow any origin, in which case
+	// the Access-Control-Allow-Origin header is set to "*".
+	//
+	// An origin may also be a pattern: the host may start with "*." to
+	// allow any subdomain (e.g. "https://*.example.com", which does not
+	// allow "https://example.com" itself), and the port may be "*" to
+	// allow any port (e.g. "http://localhost:*"). A value that starts
+	// with "^" is a regular expression, which is anchored at both ends
+	// and matched against the lowercased origin. The patterns are
+	// compiled once (see Validate).
 	//
 	// Ignored if AllowOriginsFunc is set.
 	AllowOrigins []string
@@ -43,44 +66,126 @@ type CORS struct {
 	// is called for each request.
 	AllowOriginsFunc func(string) bool
 
-	// AllowMethods indicates the list of allowed HTTP methods.
+	// AllowMethods indicates the list of allowed HTTP methods. The special
+	// value "*" can be used to allow any method. Defaults to GET, HEAD
+	// and POST.
 	AllowMethods []string
+
+	once sync.Once
+	pol  *policy
+	err  error
+}
+
+// policy is the compiled form of a CORS configuration.
+type policy struct {
+	*CORS
+	origins *originMatcher
+}
+
+// Validate returns an error if the configuration is invalid, that is,
+// if an origin pattern is invalid or if AllowCredentials is set with the
+// "*" origin.
+func (c *CORS) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+// compile returns the policy for the CORS configuration, or an error if
+// the configuration is invalid.
+func (c *CORS) compile() (*policy, error) {
+	om, err := newOriginMatcher(c.AllowOrigins)
+	if err != nil {
+		return nil, errors.New("cors: " + err.Error())
+	}
+	if om.any && c.AllowCredentials && c.AllowOriginsFunc == nil {
+		return nil, errors.New(`cors: the "*" origin is not allowed with credentials`)
+	}
+	return &policy{CORS: c, origins: om}, nil
 }
 
 // ServeHTTP is the handler for CORS OPTIONS preflight requests.
-// It sets the CORS headers and returns either 200 if the request
-// is allowed, or 403 if it isn't.
+// It sets the CORS headers and returns either 204 if the request
+// is allowed, or 403 if it isn't. A preflight request is allowed if
+// its origin is allowed, and its requested method and headers are
+// in the AllowMethods and AllowHeaders lists.
+//
+// The configuration is compiled on the first call, it must not be
+// modified afterwards. It panics on every call if the configuration is
+// invalid, as Wrap does, so Validate should be called when the handler
+// is created.
 func (c *CORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if err := setCORSHeaders(w, r, c); err != nil {
-		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-		return
+	c.once.Do(func() { c.pol, c.err = c.compile() })
+	if c.err != nil {
+		panic(c.err.Error())
 	}
+	c.pol.ServeHTTP(w, r)
+}
 
-	// Add vary by origin for cache
-	vary := w.Header().Get("Vary")
-	if vary != "" {
-		vary += ", "
+func (p *policy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the response varies by the headers of the preflight request
+	vary := []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}
+	if p.AllowPrivateNetwork {
+		vary = append(vary, "Access-Control-Request-Private-Network")
 	}
-	vary += "Origin"
-	w.Header().Set("Vary", vary)
+	addVary(w.Header(), vary...)
 
-	w.WriteHeader(200)
+	err := setCORSHeaders(w, r, p)
+	if err == nil && isPreflight(r) {
+		err = setPreflightHeaders(w, r, p)
+	}
+	if err != nil {
+		// do not leave the headers of an allowed origin on a denied request
+		for k := range w.Header() {
+			if strings.HasPrefix(k, "Access-Control-") {
+				w.Header().Del(k)
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // Wrap returns a handler that sets the CORS headers to allow a
 // cross-origin request if the request origin is a whitelisted origin.
 // The handler h is called only if the request is allowed by the CORS
-// policy.
+// policy. Preflight requests are answered directly as done by
+// ServeHTTP, without calling h. OPTIONS requests that are not
+// preflight requests are passed to h.
+//
+// It panics if the configuration is invalid (see Validate).
 func (c *CORS) Wrap(h http.Handler) http.Handler {
+	p, err := c.compile()
+	if err != nil {
+		panic(err.Error())
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := setCORSHeaders(w, r, c); err != nil {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-			return
-		}
-		h.ServeHTTP(w, r)
+		p.serve(w, r, h)
 	})
 }
 
+// serve answers the preflight request r, or calls h if r is allowed
+// by the policy.
+func (p *policy) serve(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	if isPreflight(r) {
+		p.ServeHTTP(w, r)
+		return
+	}
+
+	// always vary by origin, even if the request has no Origin header,
+	// so that shared caches don't serve the wrong response.
+	addVary(w.Header(), "Origin")
+	if err := setCORSHeaders(w, r, p); err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// defaultMethods is the list of methods allowed if AllowMethods is
+// empty.
+var defaultMethods = []string{"GET", "HEAD", "POST"}
+
 func isIn(list []string, v string) bool {
 	for _, vv := range list {
 		if vv == "*" {
@@ -93,40 +198,119 @@ func isIn(list []string, v string) bool {
 	return false
 }
 
-func setCORSHeaders(w http.ResponseWriter, r *http.Request, c *CORS) error {
+// addVary adds the header names to the Vary header of the response,
+// unless they are already present.
+func addVary(hd http.Header, names ...string) {
+	vary := hd.Get("Vary")
+	for _, name := range names {
+		found := false
+		for _, v := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), name) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if vary != "" {
+			vary += ", "
+		}
+		vary += name
+	}
+	if vary != "" {
+		hd.Set("Vary", vary)
+	}
+}
+
+// isPreflight returns true if r is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setCORSHeaders validates the origin of the request and sets the
+// headers required for all CORS requests.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, p *policy) error {
 	ori := r.Header.Get("Origin")
 	if ori == "" {
 		// not a CORS request, passthrough
 		return nil
 	}
 
+	c := p.CORS
 	if c.AllowOriginsFunc != nil {
 		if !c.AllowOriginsFunc(ori) {
 			return errors.New("invalid CORS origin: " + ori)
 		}
-	} else if !isIn(c.AllowOrigins, ori) {
+	} else if !p.origins.match(ori) {
 		return errors.New("invalid CORS origin: " + ori)
 	}
 
 	// simple CORS request (not preflight OPTIONS) requires only
 	// allow-origin, credentials and expose headers.
-	w.Header().Set("Access-Control-Allow-Origin", ori)
+	allowOri := ori
+	if p.origins.any && c.AllowOriginsFunc == nil && !c.AllowCredentials {
+		allowOri = "*"
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowOri)
 	if c.AllowCredentials {
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 	}
 	if len(c.ExposeHeaders) > 0 {
 		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ", "))
 	}
+	return nil
+}
 
-	// other CORS headers are only for preflight requests (OPTIONS)
-	if r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowMethods, ", "))
-		if c.MaxAge > 0 {
-			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+// setPreflightHeaders validates the requested method and headers of the
+// preflight request and sets the headers required for preflight requests.
+func setPreflightHeaders(w http.ResponseWriter, r *http.Request, p *policy) error {
+	c := p.CORS
+	meths := c.AllowMethods
+	if len(meths) == 0 {
+		meths = defaultMethods
+	}
+	meth := r.Header.Get("Access-Control-Request-Method")
+	if !isIn(meths, meth) {
+		return errors.New("invalid CORS method: " + meth)
+	}
+
+	var reqHeaders []string
+	for _, v := range r.Header["Access-Control-Request-Headers"] {
+		for _, hd := range strings.Split(v, ",") {
+			if hd = strings.TrimSpace(hd); hd != "" {
+				reqHeaders = append(reqHeaders, hd)
+			}
 		}
-		if len(c.AllowHeaders) > 0 {
-			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ", "))
+	}
+	for _, hd := range reqHeaders {
+		if !isIn(c.AllowHeaders, hd) {
+			return errors.New("invalid CORS header: " + hd)
 		}
 	}
+
+	// with a "*", echo the requested method and headers
+	allowMeths := strings.Join(meths, ", ")
+	if isIn(meths, "*") {
+		allowMeths = meth
+	}
+	w.Header().Set("Access-Control-Allow-Methods", allowMeths)
+	if len(c.AllowHeaders) > 0 {
+		allowHeaders := strings.Join(c.AllowHeaders, ", ")
+		if isIn(c.AllowHeaders, "*") {
+			allowHeaders = strings.Join(reqHeaders, ", ")
+		}
+		if allowHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+		}
+	}
+	if c.AllowPrivateNetwork && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		w.Header().Set("Access-Control-Allow-Private-Network", "true")
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
 	return nil
 }
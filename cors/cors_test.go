@@ -7,9 +7,11 @@ package cors
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/PuerkitoBio/httpmw"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -42,8 +44,8 @@ func TestCORSWrap(t *testing.T) {
 
 			if c.origin != "" {
 				assert.Equal(t, "X-Csrf-Token", w.Header().Get("Access-Control-Expose-Headers"), "%d: expose headers", i)
-				assert.Empty(t, w.Header().Get("Vary"), "%d: vary", i)
 			}
+			assert.Equal(t, "Origin", w.Header().Get("Vary"), "%d: vary", i)
 			w.WriteHeader(204)
 		}
 
@@ -70,10 +72,10 @@ func TestCORSHandler(t *testing.T) {
 		code      int
 		wantMeths string
 	}{
-		{"", 200, ""},                         // not a CORS request
+		{"", 204, ""},                         // not a CORS request
 		{"blah", 403, ""},                     // invalid origin
-		{allowedOrigins[0], 200, "GET, POST"}, // ok
-		{allowedOrigins[1], 200, "GET, POST"}, // ok
+		{allowedOrigins[0], 204, "GET, POST"}, // ok
+		{allowedOrigins[1], 204, "GET, POST"}, // ok
 	}
 
 	for i, c := range cases {
@@ -90,6 +92,7 @@ func TestCORSHandler(t *testing.T) {
 		require.NoError(t, err)
 		if c.origin != "" {
 			r.Header.Set("Origin", c.origin)
+			r.Header.Set("Access-Control-Request-Method", "POST")
 		}
 
 		opts.ServeHTTP(rr, r)
@@ -103,8 +106,194 @@ func TestCORSHandler(t *testing.T) {
 
 			if c.origin != "" {
 				assert.Equal(t, "X-Csrf-Token", rr.Header().Get("Access-Control-Expose-Headers"), "%d: expose headers", i)
-				assert.Equal(t, "Origin", rr.Header().Get("Vary"), "%d: vary", i)
 			}
+			assert.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", rr.Header().Get("Vary"), "%d: vary", i)
 		}
 	}
 }
+
+func TestCORSPreflight(t *testing.T) {
+	cases := []struct {
+		meths   []string
+		headers []string
+		reqMeth string
+		reqHds  []string
+		code    int
+		allowM  string
+		allowH  string
+	}{
+		{nil, nil, "GET", nil, 204, "GET, HEAD, POST", ""},
+		{nil, nil, "PUT", nil, 403, "", ""},
+		{[]string{"GET", "PUT"}, nil, "PUT", nil, 204, "GET, PUT", ""},
+		{[]string{"GET", "PUT"}, nil, "put", nil, 204, "GET, PUT", ""},
+		{[]string{"GET", "PUT"}, nil, "DELETE", nil, 403, "", ""},
+		{[]string{"*"}, nil, "DELETE", nil, 204, "DELETE", ""},
+		{[]string{"GET"}, nil, "GET", []string{"X-A"}, 403, "", ""},
+		{[]string{"GET"}, []string{"X-A", "X-B"}, "GET", []string{"x-a"}, 204, "GET", "X-A, X-B"},
+		{[]string{"GET"}, []string{"X-A", "X-B"}, "GET", []string{"x-b, X-A"}, 204, "GET", "X-A, X-B"},
+		{[]string{"GET"}, []string{"X-A", "X-B"}, "GET", []string{"X-A", "X-C"}, 403, "", ""},
+		{[]string{"GET"}, []string{"*"}, "GET", []string{"X-A, X-C"}, 204, "GET", "X-A, X-C"},
+		{[]string{"GET"}, []string{"*"}, "GET", nil, 204, "GET", ""},
+	}
+
+	for i, c := range cases {
+		opts := &CORS{
+			AllowOrigins: []string{"http://a"},
+			AllowMethods: c.meths,
+			AllowHeaders: c.headers,
+		}
+
+		called := false
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}
+		h := opts.Wrap(http.HandlerFunc(fn))
+
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest("OPTIONS", "http://host", nil)
+		require.NoError(t, err)
+		r.Header.Set("Origin", "http://a")
+		r.Header.Set("Access-Control-Request-Method", c.reqMeth)
+		for _, hd := range c.reqHds {
+			r.Header.Add("Access-Control-Request-Headers", hd)
+		}
+
+		h.ServeHTTP(rr, r)
+		assert.False(t, called, "%d: handler called", i)
+		assert.Equal(t, c.code, rr.Code, "%d: code", i)
+		assert.Equal(t, c.allowM, rr.Header().Get("Access-Control-Allow-Methods"), "%d: allowed methods", i)
+		assert.Equal(t, c.allowH, rr.Header().Get("Access-Control-Allow-Headers"), "%d: allowed headers", i)
+	}
+}
+
+func TestCORSOptionsPassthrough(t *testing.T) {
+	opts := &CORS{AllowOrigins: []string{"http://a"}}
+	h := opts.Wrap(httpmw.StatusHandler(200))
+
+	for i, ori := range []string{"", "http://a"} {
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest("OPTIONS", "http://host", nil)
+		require.NoError(t, err)
+		if ori != "" {
+			r.Header.Set("Origin", ori)
+		}
+
+		h.ServeHTTP(rr, r)
+		assert.Equal(t, 200, rr.Code, "%d: code", i)
+		assert.Equal(t, ori, rr.Header().Get("Access-Control-Allow-Origin"), "%d: allowed origin", i)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"), "%d: allowed methods", i)
+	}
+}
+
+func TestCORSInvalidPattern(t *testing.T) {
+	opts := &CORS{AllowOrigins: []string{"https://*.*.example.com"}}
+	assert.Error(t, opts.Validate(), "validate")
+	assert.Panics(t, func() { opts.Wrap(httpmw.StatusHandler(200)) }, "invalid pattern")
+}
+
+func TestCORSWildcardOrigin(t *testing.T) {
+	cases := []struct {
+		opts   *CORS
+		method string
+		want   string
+	}{
+		{&CORS{AllowOrigins: []string{"*"}}, "GET", "*"},
+		{&CORS{AllowOrigins: []string{"*"}}, "OPTIONS", "*"},
+		{&CORS{AllowOrigins: []string{"http://a"}}, "GET", "http://a"},
+		{&CORS{AllowOrigins: []string{"http://a"}, AllowCredentials: true}, "GET", "http://a"},
+		{&CORS{AllowOrigins: []string{"*"}, AllowOriginsFunc: func(string) bool { return true }, AllowCredentials: true}, "GET", "http://a"},
+	}
+	for i, c := range cases {
+		h := c.opts.Wrap(httpmw.StatusHandler(200))
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest(c.method, "http://host", nil)
+		require.NoError(t, err)
+		r.Header.Set("Origin", "http://a")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+
+		h.ServeHTTP(rr, r)
+		assert.Equal(t, c.want, rr.Header().Get("Access-Control-Allow-Origin"), "%d: allowed origin", i)
+	}
+
+	opts := &CORS{AllowOrigins: []string{"*"}, AllowCredentials: true}
+	assert.Error(t, opts.Validate(), "validate credentials with wildcard")
+	assert.Panics(t, func() { opts.Wrap(httpmw.StatusHandler(200)) }, "credentials with wildcard")
+
+	// the standalone handler panics on every call
+	r, err := http.NewRequest("OPTIONS", "http://host", nil)
+	require.NoError(t, err)
+	r.Header.Set("Origin", "http://a")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	for i := 0; i < 2; i++ {
+		assert.Panics(t, func() { opts.ServeHTTP(httptest.NewRecorder(), r) }, "%d: serve credentials with wildcard", i)
+	}
+
+	assert.NoError(t, (&CORS{AllowOrigins: []string{"*"}}).Validate(), "validate wildcard")
+}
+
+func TestCORSVary(t *testing.T) {
+	opts := &CORS{AllowOrigins: []string{"http://a"}, AllowPrivateNetwork: true}
+	h := opts.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.WriteHeader(200)
+	}))
+
+	cases := []struct {
+		method string
+		origin string
+		preset string
+		want   string
+	}{
+		{"GET", "", "", "Origin, Accept-Encoding"},
+		{"GET", "http://a", "", "Origin, Accept-Encoding"},
+		{"GET", "http://b", "", "Origin"},
+		{"GET", "http://a", "origin", "origin, Accept-Encoding"},
+		{"GET", "http://a", "Cookie", "Cookie, Origin, Accept-Encoding"},
+		{"OPTIONS", "http://a", "", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers, Access-Control-Request-Private-Network"},
+		{"OPTIONS", "http://b", "", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers, Access-Control-Request-Private-Network"},
+	}
+	for i, c := range cases {
+		rr := httptest.NewRecorder()
+		if c.preset != "" {
+			rr.Header().Set("Vary", c.preset)
+		}
+		r, err := http.NewRequest(c.method, "http://host", nil)
+		require.NoError(t, err)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		r.Header.Set("Access-Control-Request-Method", "GET")
+
+		h.ServeHTTP(rr, r)
+		assert.Equal(t, c.want, strings.Join(rr.Header()["Vary"], ", "), "%d: vary", i)
+	}
+}
+
+func TestCORSPrivateNetwork(t *testing.T) {
+	cases := []struct {
+		allow bool
+		req   string
+		want  string
+	}{
+		{false, "", ""},
+		{false, "true", ""},
+		{true, "", ""},
+		{true, "true", "true"},
+	}
+	for i, c := range cases {
+		opts := &CORS{AllowOrigins: []string{"http://a"}, AllowPrivateNetwork: c.allow}
+
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest("OPTIONS", "http://host", nil)
+		require.NoError(t, err)
+		r.Header.Set("Origin", "http://a")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		if c.req != "" {
+			r.Header.Set("Access-Control-Request-Private-Network", c.req)
+		}
+
+		opts.ServeHTTP(rr, r)
+		assert.Equal(t, 204, rr.Code, "%d: code", i)
+		assert.Equal(t, c.want, rr.Header().Get("Access-Control-Allow-Private-Network"), "%d: private network", i)
+	}
+}
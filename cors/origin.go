@@ -0,0 +1,140 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// originMatcher matches request origins against the compiled list of
+// allowed origins.
+type originMatcher struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []wildcardOrigin
+	regexps   []*regexp.Regexp
+}
+
+// wildcardOrigin is an allowed origin with a wildcard subdomain or port.
+type wildcardOrigin struct {
+	scheme string
+	// host is the host without the port. If subdomain is true, it is
+	// the parent domain, with its leading dot (e.g. ".example.com").
+	host      string
+	subdomain bool
+	// port is the port, empty if there is none, or "*" for any port.
+	port string
+}
+
+// newOriginMatcher compiles the list of allowed origins.
+func newOriginMatcher(list []string) (*originMatcher, error) {
+	m := &originMatcher{exact: make(map[string]bool)}
+	for _, v := range list {
+		switch {
+		case v == "*":
+			m.any = true
+
+		case strings.HasPrefix(v, "^"):
+			expr := strings.TrimSuffix(strings.TrimPrefix(v, "^"), "$")
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, err
+			}
+			m.regexps = append(m.regexps, re)
+
+		case strings.Contains(v, "*"):
+			wo, err := parseWildcardOrigin(strings.ToLower(v))
+			if err != nil {
+				return nil, err
+			}
+			m.wildcards = append(m.wildcards, wo)
+
+		default:
+			m.exact[strings.ToLower(v)] = true
+		}
+	}
+	return m, nil
+}
+
+func parseWildcardOrigin(v string) (wildcardOrigin, error) {
+	var wo wildcardOrigin
+
+	i := strings.Index(v, "://")
+	if i <= 0 {
+		return wo, errors.New("invalid origin pattern: " + v)
+	}
+	wo.scheme, wo.host = v[:i], v[i+3:]
+	if j := strings.LastIndex(wo.host, ":"); j >= 0 && !strings.HasSuffix(wo.host, "]") {
+		wo.host, wo.port = wo.host[:j], wo.host[j+1:]
+	}
+	if strings.HasPrefix(wo.host, "*.") {
+		wo.host = wo.host[1:]
+		wo.subdomain = true
+	}
+
+	if wo.host == "" || wo.host == "." || strings.ContainsAny(wo.host, "*/") ||
+		strings.ContainsAny(wo.scheme, "*/") ||
+		(wo.port != "*" && strings.Contains(wo.port, "*")) {
+		return wo, errors.New("invalid origin pattern: " + v)
+	}
+	return wo, nil
+}
+
+// match returns true if the origin is allowed.
+func (m *originMatcher) match(origin string) bool {
+	if m.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	if len(m.wildcards) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" ||
+		u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+	host, port := u.Host, ""
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host, port = h, p
+		if strings.Contains(h, ":") {
+			// IPv6 address, restore the brackets
+			host = "[" + h + "]"
+		}
+	}
+	for _, wo := range m.wildcards {
+		if wo.match(u.Scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (wo wildcardOrigin) match(scheme, host, port string) bool {
+	if scheme != wo.scheme {
+		return false
+	}
+	if wo.port != "*" && port != wo.port {
+		return false
+	}
+	if wo.subdomain {
+		// the leading dot of wo.host prevents matching e.g. evilexample.com
+		return len(host) > len(wo.host) && strings.HasSuffix(host, wo.host)
+	}
+	return host == wo.host
+}
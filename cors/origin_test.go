@@ -0,0 +1,76 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginMatcher(t *testing.T) {
+	cases := []struct {
+		allow  []string
+		origin string
+		want   bool
+	}{
+		{nil, "http://a", false},
+		{[]string{"*"}, "http://a", true},
+		{[]string{"http://A"}, "http://a", true},
+		{[]string{"http://a"}, "HTTP://A", true},
+		{[]string{"http://a"}, "https://a", false},
+		{[]string{"http://a"}, "http://a:8080", false},
+
+		{[]string{"https://*.example.com"}, "https://a.example.com", true},
+		{[]string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{[]string{"https://*.example.com"}, "https://A.Example.com", true},
+		{[]string{"https://*.example.com"}, "https://example.com", false},
+		{[]string{"https://*.example.com"}, "https://.example.com", false},
+		{[]string{"https://*.example.com"}, "https://evilexample.com", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com.evil.com", false},
+		{[]string{"https://*.example.com"}, "http://a.example.com", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com:8443", false},
+		{[]string{"https://*.example.com:8443"}, "https://a.example.com:8443", true},
+		{[]string{"https://*.example.com"}, "https://a.example.com/path", false},
+		{[]string{"https://*.example.com"}, "https://user@a.example.com", false},
+
+		{[]string{"http://localhost:*"}, "http://localhost:3000", true},
+		{[]string{"http://localhost:*"}, "http://localhost", true},
+		{[]string{"http://localhost:*"}, "http://localhost.evil.com:3000", false},
+		{[]string{"http://localhost:*"}, "https://localhost:3000", false},
+		{[]string{"http://*.localhost:*"}, "http://a.localhost:3000", true},
+		{[]string{"http://[::1]:*"}, "http://[::1]:3000", true},
+
+		{[]string{`^https://pr-\d+\.preview\.example\.com$`}, "https://pr-12.preview.example.com", true},
+		{[]string{`^https://pr-\d+\.preview\.example\.com`}, "https://pr-12.preview.example.com.evil.com", false},
+		{[]string{`^https://pr-\d+\.preview\.example\.com`}, "https://pr-x.preview.example.com", false},
+		{[]string{`^https://a|https://b`}, "https://b", true},
+		{[]string{`^https://a|https://b`}, "https://bc", false},
+
+		{[]string{"http://x", "https://*.example.com", "^http://y"}, "http://y", true},
+	}
+	for i, c := range cases {
+		m, err := newOriginMatcher(c.allow)
+		if assert.NoError(t, err, "%d: compile", i) {
+			assert.Equal(t, c.want, m.match(c.origin), "%d: %s", i, c.origin)
+		}
+	}
+}
+
+func TestOriginMatcherInvalid(t *testing.T) {
+	cases := []string{
+		"*.example.com",
+		"https://*",
+		"https://a.*.example.com",
+		"https://*.example.com:8*",
+		"*://example.com",
+		"https://*.example.com/path",
+		"^https://(",
+	}
+	for i, c := range cases {
+		_, err := newOriginMatcher([]string{c})
+		assert.Error(t, err, "%d: %s", i, c)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Route associates a CORS configuration with the requests for a path
+// pattern and methods.
+type Route struct {
+	// Path is the path pattern of the route. If it ends with a slash, it
+	// matches any path that starts with it, as for http.ServeMux (e.g.
+	// "/api/"). Otherwise it is matched with path.Match (e.g. "/users/*").
+	Path string
+
+	// Methods is the list of methods of the route. If it is empty, the
+	// route matches any method. For preflight requests, the method is
+	// the one requested in the Access-Control-Request-Method header.
+	Methods []string
+
+	// CORS is the CORS configuration that applies to the route.
+	CORS *CORS
+}
+
+// PolicySet holds the configuration for a CORS middleware that applies
+// a distinct CORS configuration per route.
+type PolicySet struct {
+	// Routes is the list of routes of the policy set. The first route
+	// that matches a request is used.
+	Routes []Route
+
+	once   sync.Once
+	routes []compiledRoute
+	err    error
+}
+
+type compiledRoute struct {
+	Route
+	pol *policy
+}
+
+// Validate returns an error if a route is invalid, that is, if it has
+// no CORS configuration, an invalid path pattern or an invalid CORS
+// configuration (see CORS.Validate).
+func (ps *PolicySet) Validate() error {
+	_, err := ps.compile()
+	return err
+}
+
+// compile returns the compiled routes of the policy set, or an error if
+// a route is invalid.
+func (ps *PolicySet) compile() ([]compiledRoute, error) {
+	routes := make([]compiledRoute, 0, len(ps.Routes))
+	for _, rt := range ps.Routes {
+		if rt.CORS == nil {
+			return nil, errors.New("cors: no CORS configuration for route " + rt.Path)
+		}
+		if _, err := path.Match(rt.Path, ""); err != nil {
+			return nil, errors.New("cors: invalid route path " + rt.Path + ": " + err.Error())
+		}
+		pol, err := rt.CORS.compile()
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, compiledRoute{Route: rt, pol: pol})
+	}
+	return routes, nil
+}
+
+// ServeHTTP is the handler for CORS OPTIONS preflight requests for all
+// routes of the policy set. It finds the route for the requested path and
+// method, and answers as CORS.ServeHTTP does for that route's configuration.
+// If no route matches, it returns 403 for preflight requests and 204 for
+// other requests.
+//
+// The configuration is compiled on the first call, it must not be
+// modified afterwards. It panics on every call if the configuration is
+// invalid, as Wrap does, so Validate should be called when the handler
+// is created.
+func (ps *PolicySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.once.Do(func() { ps.routes, ps.err = ps.compile() })
+	if ps.err != nil {
+		panic(ps.err.Error())
+	}
+	serveRoutes(w, r, ps.routes, nil)
+}
+
+// Wrap returns a handler that applies the CORS configuration of the route
+// that matches the request, as done by CORS.Wrap. If no route matches,
+// no CORS header is set, the handler h is called for actual requests and
+// a 403 is returned for preflight requests.
+//
+// It panics if the configuration is invalid (see Validate).
+func (ps *PolicySet) Wrap(h http.Handler) http.Handler {
+	routes, err := ps.compile()
+	if err != nil {
+		panic(err.Error())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveRoutes(w, r, routes, h)
+	})
+}
+
+// serveRoutes serves the request r with the policy of the matching route.
+// If h is nil, it serves r as an OPTIONS request.
+func serveRoutes(w http.ResponseWriter, r *http.Request, routes []compiledRoute, h http.Handler) {
+	preflight := isPreflight(r)
+	meth := r.Method
+	if preflight {
+		meth = r.Header.Get("Access-Control-Request-Method")
+	}
+
+	var pol *policy
+	for _, rt := range routes {
+		if rt.match(r.URL.Path, meth) {
+			pol = rt.pol
+			break
+		}
+	}
+
+	switch {
+	case pol == nil && preflight:
+		addVary(w.Header(), "Origin", "Access-Control-Request-Method")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case pol == nil && h == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case pol == nil:
+		addVary(w.Header(), "Origin")
+		h.ServeHTTP(w, r)
+	case h == nil:
+		pol.ServeHTTP(w, r)
+	default:
+		pol.serve(w, r, h)
+	}
+}
+
+func (rt *compiledRoute) match(p, meth string) bool {
+	if len(rt.Methods) > 0 {
+		found := false
+		for _, m := range rt.Methods {
+			if strings.EqualFold(m, meth) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if strings.HasSuffix(rt.Path, "/") {
+		return strings.HasPrefix(p, rt.Path)
+	}
+	ok, _ := path.Match(rt.Path, p)
+	return ok
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicySet() *PolicySet {
+	public := &CORS{AllowOrigins: []string{"*"}, AllowMethods: []string{"GET", "HEAD"}}
+	spa := &CORS{
+		AllowOrigins:     []string{"https://app.example.com"},
+		AllowMethods:     []string{"POST", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Content-Type"},
+		AllowCredentials: true,
+	}
+	return &PolicySet{
+		Routes: []Route{
+			{Path: "/api/", Methods: []string{"GET", "HEAD"}, CORS: public},
+			{Path: "/api/", Methods: []string{"POST", "PUT", "DELETE"}, CORS: spa},
+			{Path: "/files/*", CORS: public},
+		},
+	}
+}
+
+func TestPolicySetWrap(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		origin string
+		code   int
+		allow  string
+		creds  string
+	}{
+		{"GET", "/api/items", "http://any", 200, "*", ""},
+		{"GET", "/api/items", "https://app.example.com", 200, "*", ""},
+		{"POST", "/api/items", "https://app.example.com", 200, "https://app.example.com", "true"},
+		{"POST", "/api/items", "http://any", 403, "", ""},
+		{"PATCH", "/api/items", "http://any", 200, "", ""},
+		{"GET", "/files/a", "http://any", 200, "*", ""},
+		{"GET", "/files/a/b", "http://any", 200, "", ""},
+		{"GET", "/other", "http://any", 200, "", ""},
+		{"GET", "/other", "", 200, "", ""},
+	}
+
+	h := testPolicySet().Wrap(httpmw.StatusHandler(200))
+	for i, c := range cases {
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest(c.method, "http://host"+c.path, nil)
+		require.NoError(t, err)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+
+		h.ServeHTTP(rr, r)
+		assert.Equal(t, c.code, rr.Code, "%d: code", i)
+		assert.Equal(t, c.allow, rr.Header().Get("Access-Control-Allow-Origin"), "%d: allowed origin", i)
+		assert.Equal(t, c.creds, rr.Header().Get("Access-Control-Allow-Credentials"), "%d: credentials", i)
+		assert.Equal(t, "Origin", rr.Header().Get("Vary"), "%d: vary", i)
+	}
+}
+
+func TestPolicySetPreflight(t *testing.T) {
+	cases := []struct {
+		path    string
+		origin  string
+		reqMeth string
+		code    int
+		allow   string
+		allowM  string
+	}{
+		{"/api/items", "http://any", "GET", 204, "*", "GET, HEAD"},
+		{"/api/items", "http://any", "DELETE", 403, "", ""},
+		{"/api/items", "https://app.example.com", "DELETE", 204, "https://app.example.com", "POST, PUT, DELETE"},
+		{"/api/items", "https://app.example.com", "PATCH", 403, "", ""},
+		{"/files/a", "http://any", "GET", 204, "*", "GET, HEAD"},
+		{"/files/a", "http://any", "PUT", 403, "", ""},
+		{"/other", "http://any", "GET", 403, "", ""},
+	}
+
+	ps := testPolicySet()
+	wrapped := ps.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for %s", r.URL.Path)
+	}))
+	for _, h := range []http.Handler{ps, wrapped} {
+		for i, c := range cases {
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest("OPTIONS", "http://host"+c.path, nil)
+			require.NoError(t, err)
+			r.Header.Set("Origin", c.origin)
+			r.Header.Set("Access-Control-Request-Method", c.reqMeth)
+
+			h.ServeHTTP(rr, r)
+			assert.Equal(t, c.code, rr.Code, "%d: code", i)
+			assert.Equal(t, c.allow, rr.Header().Get("Access-Control-Allow-Origin"), "%d: allowed origin", i)
+			assert.Equal(t, c.allowM, rr.Header().Get("Access-Control-Allow-Methods"), "%d: allowed methods", i)
+		}
+	}
+
+	// not a preflight request
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest("OPTIONS", "http://host/other", nil)
+	require.NoError(t, err)
+	ps.ServeHTTP(rr, r)
+	assert.Equal(t, 204, rr.Code, "not preflight")
+}
+
+func TestPolicySetInvalid(t *testing.T) {
+	cases := []*PolicySet{
+		{Routes: []Route{{Path: "/a"}}},
+		{Routes: []Route{{Path: "/a[", CORS: &CORS{}}}},
+		{Routes: []Route{{Path: "/a", CORS: &CORS{AllowOrigins: []string{"*"}, AllowCredentials: true}}}},
+	}
+	for i, ps := range cases {
+		assert.Error(t, ps.Validate(), "%d: validate", i)
+		assert.Panics(t, func() { ps.Wrap(httpmw.StatusHandler(200)) }, "%d: wrap", i)
+
+		r, err := http.NewRequest("OPTIONS", "http://host/a", nil)
+		require.NoError(t, err)
+		r.Header.Set("Origin", "http://a")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		assert.Panics(t, func() { ps.ServeHTTP(httptest.NewRecorder(), r) }, "%d: serve", i)
+		assert.Panics(t, func() { ps.ServeHTTP(httptest.NewRecorder(), r) }, "%d: serve again", i)
+	}
+	assert.NoError(t, (&PolicySet{Routes: []Route{{Path: "/a", CORS: &CORS{}}}}).Validate(), "valid")
+}
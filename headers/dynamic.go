@@ -0,0 +1,219 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package headers
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueFunc returns a value computed for the request r.
+type ValueFunc func(r *http.Request) string
+
+// Dynamic holds the configuration for a middleware that adds headers
+// with values computed for each request.
+type Dynamic struct {
+	// Headers is the set of headers to add, with the same "+" and "-"
+	// prefix semantics as Headers. The values may contain placeholders
+	// in the form {name}, which are replaced with the value returned by
+	// the corresponding ValueFunc for the request. The supported
+	// placeholders are:
+	//
+	//     host: host (and possibly port) of the request
+	//     hostname: host name of the server
+	//     method: method of the request (e.g. GET)
+	//     path: path section of the request URL
+	//     remote_ip: IP address of the client, without the port
+	//     request_id: request ID, from the RequestIDHeader header
+	//     time: current time in the HTTP date format (UTC)
+	//     unix_time: current time in seconds since the epoch
+	//
+	// Unknown placeholders are left as-is.
+	Headers Headers
+
+	// Placeholders is a map of additional placeholders, keyed by name
+	// (without the braces). It can also redefine the built-in ones.
+	Placeholders map[string]ValueFunc
+
+	// RequestIDHeader is the name of the header that contains the request
+	// ID. Defaults to X-Request-Id.
+	RequestIDHeader string
+
+	// OnWriteHeader applies the headers when the response's header is
+	// written instead of before calling the handler, so that they take
+	// precedence over the headers set by the handler.
+	OnWriteHeader bool
+}
+
+// Wrap returns a handler that adds the headers to the response's Header,
+// either before calling the handler h or when the response's header is
+// written, depending on OnWriteHeader.
+func (d *Dynamic) Wrap(h http.Handler) http.Handler {
+	funcs := builtinPlaceholders(d.RequestIDHeader)
+	for k, fn := range d.Placeholders {
+		funcs[k] = fn
+	}
+	tpls := make(map[string][]template, len(d.Headers))
+	for k, v := range d.Headers {
+		for _, vv := range v {
+			tpls[k] = append(tpls[k], parseTemplate(vv, funcs))
+		}
+	}
+	onWrite := d.OnWriteHeader
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fn := func() {
+			vals := make(map[string][]string, len(tpls))
+			for k, v := range tpls {
+				vv := make([]string, len(v))
+				for i, tpl := range v {
+					vv[i] = tpl.execute(r)
+				}
+				vals[k] = vv
+			}
+			apply(w.Header(), vals)
+		}
+
+		if !onWrite {
+			fn()
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		hw := &headerWriter{ResponseWriter: w, fn: fn}
+		h.ServeHTTP(hw, r)
+		// the response's header may not have been written explicitly
+		hw.apply()
+	})
+}
+
+func builtinPlaceholders(reqIDHeader string) map[string]ValueFunc {
+	if reqIDHeader == "" {
+		reqIDHeader = "X-Request-Id"
+	}
+	hostname, _ := os.Hostname()
+
+	return map[string]ValueFunc{
+		"host":     func(r *http.Request) string { return r.Host },
+		"hostname": func(r *http.Request) string { return hostname },
+		"method":   func(r *http.Request) string { return r.Method },
+		"path":     func(r *http.Request) string { return r.URL.Path },
+		"remote_ip": func(r *http.Request) string {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				return host
+			}
+			return r.RemoteAddr
+		},
+		"request_id": func(r *http.Request) string { return r.Header.Get(reqIDHeader) },
+		"time":       func(r *http.Request) string { return time.Now().UTC().Format(http.TimeFormat) },
+		"unix_time":  func(r *http.Request) string { return strconv.FormatInt(time.Now().Unix(), 10) },
+	}
+}
+
+// template is a parsed header value, made of literal strings and
+// placeholders.
+type template []templatePart
+
+type templatePart struct {
+	lit string
+	fn  ValueFunc
+}
+
+func parseTemplate(s string, funcs map[string]ValueFunc) template {
+	var tpl template
+	for {
+		start := strings.Index(s, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+
+		fn := funcs[s[start+1:end]]
+		if fn == nil {
+			// unknown placeholder, keep as literal
+			tpl = append(tpl, templatePart{lit: s[:end+1]})
+		} else {
+			if start > 0 {
+				tpl = append(tpl, templatePart{lit: s[:start]})
+			}
+			tpl = append(tpl, templatePart{fn: fn})
+		}
+		s = s[end+1:]
+	}
+	if s != "" || len(tpl) == 0 {
+		tpl = append(tpl, templatePart{lit: s})
+	}
+	return tpl
+}
+
+func (tpl template) execute(r *http.Request) string {
+	if len(tpl) == 1 && tpl[0].fn == nil {
+		return tpl[0].lit
+	}
+	var buf []byte
+	for _, p := range tpl {
+		if p.fn != nil {
+			buf = append(buf, p.fn(r)...)
+		} else {
+			buf = append(buf, p.lit...)
+		}
+	}
+	return string(buf)
+}
+
+// headerWriter is a response writer that calls fn once, right before
+// the response's header is written.
+type headerWriter struct {
+	http.ResponseWriter
+	fn   func()
+	done bool
+}
+
+func (w *headerWriter) apply() {
+	if !w.done {
+		w.done = true
+		w.fn()
+	}
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack is not supported")
+	}
+	return hj.Hijack()
+}
+
+func (w *headerWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w *headerWriter) Flush() {
+	f, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		w.apply()
+		f.Flush()
+	}
+}
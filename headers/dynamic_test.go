@@ -0,0 +1,113 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamicPlaceholders(t *testing.T) {
+	hostname, _ := os.Hostname()
+	d := &Dynamic{
+		Headers: Headers{
+			"A": {"{request_id}"},
+			"B": {"ip={remote_ip} host={host}", "{method} {path}"},
+			"C": {"{hostname}"},
+			"D": {"{unknown} {tenant} {"},
+			"E": {"{x}{tenant}{x}"},
+		},
+		Placeholders: map[string]ValueFunc{
+			"tenant": func(r *http.Request) string { return r.Header.Get("X-Tenant") },
+			"x":      func(r *http.Request) string { return "x" },
+		},
+	}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), d)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com/a/b", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Set("X-Tenant", "t1")
+
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code, "status")
+	assert.Equal(t, http.Header{
+		"A": {"abc"},
+		"B": {"ip=1.2.3.4 host=example.com", "GET /a/b"},
+		"C": {hostname},
+		"D": {"{unknown} t1 {"},
+		"E": {"xt1x"},
+	}, w.Header(), "headers")
+}
+
+func TestDynamicTime(t *testing.T) {
+	d := &Dynamic{
+		Headers:         Headers{"Date": {"{time}"}, "X-Unix": {"{unix_time}"}, "X-Id": {"{request_id}"}},
+		RequestIDHeader: "Id",
+	}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), d)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Id", "xyz")
+
+	before := time.Now().Unix()
+	h.ServeHTTP(w, r)
+	after := time.Now().Unix()
+
+	tm, err := http.ParseTime(w.Header().Get("Date"))
+	if assert.NoError(t, err, "parse time") {
+		assert.True(t, tm.Unix() >= before && tm.Unix() <= after, "time")
+	}
+	unix, err := strconv.ParseInt(w.Header().Get("X-Unix"), 10, 64)
+	if assert.NoError(t, err, "parse unix time") {
+		assert.True(t, unix >= before && unix <= after, "unix time")
+	}
+	assert.Equal(t, "xyz", w.Header().Get("X-Id"), "request id")
+}
+
+func TestDynamicOnWriteHeader(t *testing.T) {
+	hd := Headers{
+		"A":  {"a"},
+		"+B": {"b"},
+		"-C": {""},
+	}
+
+	cases := []struct {
+		onWrite bool
+		write   func(w http.ResponseWriter)
+		want    http.Header
+	}{
+		{false, func(w http.ResponseWriter) { w.WriteHeader(201) }, http.Header{"A": {"z"}, "B": {"b", "y"}, "C": {"x"}}},
+		{true, func(w http.ResponseWriter) { w.WriteHeader(201) }, http.Header{"A": {"a"}, "B": {"y", "b"}}},
+		{true, func(w http.ResponseWriter) { w.Write([]byte("ok")) }, http.Header{"A": {"a"}, "B": {"y", "b"}}},
+		{true, func(w http.ResponseWriter) {}, http.Header{"A": {"a"}, "B": {"y", "b"}}},
+	}
+	for i, c := range cases {
+		d := &Dynamic{Headers: hd, OnWriteHeader: c.onWrite}
+		h := httpmw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("A", "z")
+			w.Header().Add("B", "y")
+			w.Header().Set("C", "x")
+			c.write(w)
+			// changes after the header is written have no effect
+			w.Header().Set("A", "after")
+		}), d)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		h.ServeHTTP(w, r)
+
+		got := w.Result().Header
+		got.Del("Content-Type")
+		assert.Equal(t, c.want, got, "%d: headers", i)
+	}
+}
@@ -3,7 +3,9 @@
 // license that can be found in the LICENSE file.
 
 // Package headers defines a middleware that adds static headers to
-// the requests.
+// the responses, a variant that supports per-request values computed
+// from placeholders, and a middleware that modifies the request
+// headers before they reach the handler.
 package headers
 
 import "net/http"
@@ -12,7 +14,12 @@ import "net/http"
 // interface so that the headers are added to each request using the
 // middleware. By default the header values are set (replace any existing
 // value), but the behaviour can be controlled by prepending a "+" to
-// the header name (add the value) or a "-" (remove this header).
+// the header name (add the value) or a "-" (remove this header). A ">"
+// renames the header to the name given as value, e.g. ">X-Old" with
+// the value "X-New" moves the values of X-Old to X-New.
+//
+// The headers are applied in this order: removals, renames, then the
+// headers to set and those to add.
 type Headers http.Header
 
 // Add adds the value v to the header k.
@@ -38,24 +45,83 @@ func (hd Headers) Del(k string) {
 // Wrap returns a handler that adds the headers to the response's Header.
 func (hd Headers) Wrap(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		resHd := w.Header()
-		for k, v := range hd {
+		apply(w.Header(), hd)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apply applies the headers in src to dst, following the prefix
+// semantics of Headers. The operations are applied in a fixed order:
+// removals ("-"), renames (">"), then replacements and additions ("+").
+func apply(dst http.Header, src map[string][]string) {
+	for _, op := range []byte{'-', '>', ' ', '+'} {
+		for k, v := range src {
 			start := byte(' ')
-			if len(k) > 0 {
+			if len(k) > 0 && (k[0] == '-' || k[0] == '>' || k[0] == '+') {
 				start = k[0]
 			}
+			if start != op {
+				continue
+			}
+
 			switch start {
 			case '+':
 				k := k[1:]
 				for _, vv := range v {
-					resHd.Add(k, vv)
+					dst.Add(k, vv)
 				}
 			case '-':
-				resHd.Del(k[1:])
+				dst.Del(k[1:])
+			case '>':
+				if len(v) == 0 || v[0] == "" {
+					continue
+				}
+				old := http.CanonicalHeaderKey(k[1:])
+				if vals, ok := dst[old]; ok {
+					delete(dst, old)
+					dst[http.CanonicalHeaderKey(v[0])] = vals
+				}
 			default:
-				resHd[k] = v
+				dst[k] = append([]string(nil), v...)
 			}
 		}
+	}
+}
+
+// RequestHeaders is an http.Header map that implements the httpmw.Wrapper
+// interface so that the headers of each request are modified before the
+// request is passed to the handler. It supports the same prefixes as
+// Headers, e.g. to remove spoofable headers received from the client.
+type RequestHeaders http.Header
+
+// Add adds the value v to the header k.
+func (hd RequestHeaders) Add(k, v string) {
+	http.Header(hd).Add(k, v)
+}
+
+// Set sets the value v to the header k, replacing any existing value.
+func (hd RequestHeaders) Set(k, v string) {
+	http.Header(hd).Set(k, v)
+}
+
+// Get returns the first value of the header k.
+func (hd RequestHeaders) Get(k string) string {
+	return http.Header(hd).Get(k)
+}
+
+// Del removes the header k.
+func (hd RequestHeaders) Del(k string) {
+	http.Header(hd).Del(k)
+}
+
+// Wrap returns a handler that applies the headers to the request's Header
+// before calling the handler h.
+func (hd RequestHeaders) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+		apply(r.Header, hd)
 		h.ServeHTTP(w, r)
 	})
 }
@@ -71,3 +71,65 @@ func TestHeaders(t *testing.T) {
 		"D": {"d"},
 	}, map[string][]string(w.HeaderMap), "response content")
 }
+
+func TestHeadersRename(t *testing.T) {
+	head := make(Headers)
+	head.Add(">x-old", "x-new")
+	head.Add(">Missing", "Other")
+	head.Add("+X-New", "n")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+	h := head.Wrap(httpmw.StatusHandler(204))
+	w.Header().Add("X-Old", "a")
+	w.Header().Add("X-Old", "b")
+
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 204, w.Code, "status")
+	assert.Equal(t, http.Header{
+		"X-New": {"a", "b", "n"},
+	}, w.Header(), "headers")
+}
+
+func TestRequestHeaders(t *testing.T) {
+	head := make(RequestHeaders)
+	head.Add("-X-User-Id", "")
+	head.Add(">X-Auth-User", "X-User-Id")
+	head.Set("X-Internal", "1")
+	head.Add("+Via", "gateway")
+
+	cases := []struct {
+		in   http.Header
+		want http.Header
+	}{
+		{
+			http.Header{},
+			http.Header{"X-Internal": {"1"}, "Via": {"gateway"}},
+		},
+		{
+			http.Header{"X-User-Id": {"spoofed"}, "X-Internal": {"0"}, "Via": {"proxy"}},
+			http.Header{"X-Internal": {"1"}, "Via": {"proxy", "gateway"}},
+		},
+		{
+			http.Header{"X-User-Id": {"spoofed"}, "X-Auth-User": {"u1"}},
+			http.Header{"X-User-Id": {"u1"}, "X-Internal": {"1"}, "Via": {"gateway"}},
+		},
+	}
+	for i, c := range cases {
+		var got http.Header
+		h := httpmw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header
+			w.WriteHeader(200)
+		}), head)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/", nil)
+		r.Header = c.in
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, 200, w.Code, "%d: status", i)
+		assert.Empty(t, w.Header(), "%d: response headers", i)
+		assert.Equal(t, c.want, got, "%d: request headers", i)
+	}
+	assert.Equal(t, 4, len(head), "length of middleware")
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadshed
+
+import (
+	"math"
+	"time"
+)
+
+// Algorithm defines the Update method that adjusts the concurrency limit
+// from the observed latency of the requests.
+type Algorithm interface {
+	// Update returns the new limit given the current limit, the latency
+	// rtt of a completed request, the number of requests in flight when
+	// it started (including itself) and whether it was dropped (e.g. it
+	// timed out). It is called with the LoadShed locked, so it does not
+	// need to be safe for concurrent use.
+	Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64
+}
+
+// Default configuration of the AIMD algorithm.
+const (
+	DefaultAIMDThreshold = 100 * time.Millisecond
+	DefaultAIMDBackoff   = 0.9
+)
+
+// AIMD is an Algorithm that increases the limit by 1 while the latency is
+// below Threshold, and decreases it multiplicatively otherwise (additive
+// increase, multiplicative decrease).
+type AIMD struct {
+	// Threshold is the latency above which the limit is decreased.
+	// Defaults to DefaultAIMDThreshold.
+	Threshold time.Duration
+
+	// Backoff is the factor applied to the limit when it is decreased,
+	// between 0 and 1. Defaults to DefaultAIMDBackoff.
+	Backoff float64
+}
+
+// Update implements Algorithm for the AIMD.
+func (a *AIMD) Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	threshold := a.Threshold
+	if threshold <= 0 {
+		threshold = DefaultAIMDThreshold
+	}
+	backoff := a.Backoff
+	if backoff <= 0 || backoff >= 1 {
+		backoff = DefaultAIMDBackoff
+	}
+
+	if dropped || rtt > threshold {
+		return limit * backoff
+	}
+	// only increase if the limit is actually used
+	if float64(inFlight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Default configuration of the Gradient algorithm.
+const (
+	DefaultGradientSmoothing = 0.2
+	DefaultGradientTolerance = 1.5
+	DefaultGradientWindow    = 600
+)
+
+// Gradient is an Algorithm that adjusts the limit from the ratio (the
+// gradient) of the long-term average latency to the latency of the
+// requests, so that the limit decreases when the latency increases from
+// its usual value. It does not require a latency threshold. It is
+// similar to the Gradient2 limit of Netflix's concurrency-limits.
+type Gradient struct {
+	// Smoothing is the weight of a new limit, between 0 and 1. Defaults to
+	// DefaultGradientSmoothing.
+	Smoothing float64
+
+	// Tolerance is the ratio of the latency to the long-term latency that
+	// is tolerated before decreasing the limit, >= 1. Defaults to
+	// DefaultGradientTolerance.
+	Tolerance float64
+
+	// Window is the number of requests of the long-term average latency.
+	// Defaults to DefaultGradientWindow.
+	Window int
+
+	longRTT float64
+}
+
+// Update implements Algorithm for the Gradient.
+func (g *Gradient) Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	smoothing := g.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = DefaultGradientSmoothing
+	}
+	tolerance := g.Tolerance
+	if tolerance < 1 {
+		tolerance = DefaultGradientTolerance
+	}
+	window := g.Window
+	if window <= 0 {
+		window = DefaultGradientWindow
+	}
+
+	if dropped {
+		return limit * (1 - smoothing/2)
+	}
+	short := float64(rtt)
+	if short <= 0 {
+		return limit
+	}
+	if g.longRTT == 0 {
+		g.longRTT = short
+	} else {
+		g.longRTT += (short - g.longRTT) / float64(window)
+	}
+	// the latency is not affected by the limit if it is not used
+	if float64(inFlight) < limit/2 {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, tolerance*g.longRTT/short))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-smoothing) + newLimit*smoothing
+}
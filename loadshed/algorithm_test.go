@@ -0,0 +1,56 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadshed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAIMD(t *testing.T) {
+	cases := []struct {
+		alg      *AIMD
+		rtt      time.Duration
+		inFlight int
+		dropped  bool
+		want     float64
+	}{
+		{&AIMD{}, 10 * time.Millisecond, 10, false, 21},
+		{&AIMD{}, 10 * time.Millisecond, 9, false, 20},
+		{&AIMD{}, 200 * time.Millisecond, 10, false, 18},
+		{&AIMD{}, 10 * time.Millisecond, 10, true, 18},
+		{&AIMD{Threshold: time.Second, Backoff: 0.5}, 200 * time.Millisecond, 10, false, 21},
+		{&AIMD{Threshold: time.Second, Backoff: 0.5}, 2 * time.Second, 10, false, 10},
+	}
+	for i, c := range cases {
+		assert.InDelta(t, c.want, c.alg.Update(20, c.rtt, c.inFlight, c.dropped), 1e-9, "%d: limit", i)
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g := &Gradient{}
+	limit := 20.0
+
+	// stable latency, limit used: increases
+	for i := 0; i < 10; i++ {
+		limit = g.Update(limit, 10*time.Millisecond, int(limit), false)
+	}
+	assert.True(t, limit > 25, "increased: %f", limit)
+
+	// limit not used: unchanged
+	assert.Equal(t, limit, g.Update(limit, 10*time.Millisecond, 1, false), "not used")
+
+	// latency much higher than usual: decreases
+	high := limit
+	for i := 0; i < 10; i++ {
+		limit = g.Update(limit, 100*time.Millisecond, int(limit), false)
+	}
+	assert.True(t, limit < high, "decreased: %f < %f", limit, high)
+
+	// dropped: decreases
+	assert.True(t, g.Update(limit, 0, int(limit), true) < limit, "dropped")
+}
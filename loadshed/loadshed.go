@@ -0,0 +1,182 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package loadshed implements a middleware that limits the number of
+// requests executing at the same time with a limit that adapts to the
+// observed latency, and sheds the excess requests.
+package loadshed
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority is the priority class of a request. When the limit is reached,
+// the requests with the lowest priority are shed first.
+type Priority int
+
+// List of priority classes.
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+// DefaultShares is the default fraction of the limit that can be used by
+// the requests of each priority class.
+var DefaultShares = map[Priority]float64{
+	PriorityLow:      0.5,
+	PriorityNormal:   0.8,
+	PriorityHigh:     0.9,
+	PriorityCritical: 1,
+}
+
+// Default configuration of the LoadShed.
+const (
+	DefaultInitialLimit = 20
+	DefaultMinLimit     = 1
+	DefaultMaxLimit     = 1000
+	DefaultRetryAfter   = time.Second
+)
+
+// LoadShed holds the configuration for the load shedding middleware. The
+// limit is shared by all handlers wrapped by the same LoadShed, so it
+// must not be copied after its first use.
+type LoadShed struct {
+	// Algorithm adjusts the limit from the latency of the requests.
+	// Defaults to a Gradient.
+	Algorithm Algorithm
+
+	// InitialLimit is the initial concurrency limit. Defaults to
+	// DefaultInitialLimit.
+	InitialLimit int
+
+	// MinLimit is the minimum concurrency limit. Defaults to
+	// DefaultMinLimit.
+	MinLimit int
+
+	// MaxLimit is the maximum concurrency limit. Defaults to
+	// DefaultMaxLimit.
+	MaxLimit int
+
+	// PriorityFunc returns the priority class of the request, e.g.
+	// PriorityCritical for health checks or PriorityHigh for paid tenants.
+	// If it is nil, all requests have the PriorityNormal class.
+	PriorityFunc func(r *http.Request) Priority
+
+	// Shares is the fraction of the limit that can be used by the requests
+	// of each priority class, between 0 and 1. A request is shed if the
+	// number of requests in flight is at or above that fraction of the
+	// limit. Defaults to DefaultShares. A class that is not in Shares can
+	// use the whole limit.
+	Shares map[Priority]float64
+
+	// RetryAfter is the value of the Retry-After header of the shed
+	// requests, rounded up to the second. Defaults to DefaultRetryAfter.
+	RetryAfter time.Duration
+
+	once     sync.Once
+	mu       sync.Mutex
+	alg      Algorithm
+	limit    float64
+	min, max float64
+	inFlight int
+}
+
+func (ls *LoadShed) init() {
+	ls.alg = ls.Algorithm
+	if ls.alg == nil {
+		ls.alg = &Gradient{}
+	}
+	ls.min = float64(ls.MinLimit)
+	if ls.MinLimit <= 0 {
+		ls.min = DefaultMinLimit
+	}
+	ls.max = float64(ls.MaxLimit)
+	if ls.MaxLimit <= 0 {
+		ls.max = DefaultMaxLimit
+	}
+	ls.limit = float64(ls.InitialLimit)
+	if ls.InitialLimit <= 0 {
+		ls.limit = DefaultInitialLimit
+	}
+	ls.limit = math.Max(ls.min, math.Min(ls.max, ls.limit))
+}
+
+// Limit returns the current concurrency limit.
+func (ls *LoadShed) Limit() int {
+	ls.once.Do(ls.init)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return int(ls.limit)
+}
+
+// InFlight returns the number of requests in flight.
+func (ls *LoadShed) InFlight() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.inFlight
+}
+
+// Wrap returns a handler that calls h if the number of requests in flight
+// is below the share of the current limit of the request's priority class,
+// otherwise it returns a status code 503 with a Retry-After header. The
+// latency of h is used to adjust the limit.
+func (ls *LoadShed) Wrap(h http.Handler) http.Handler {
+	ls.once.Do(ls.init)
+
+	shares := ls.Shares
+	if shares == nil {
+		shares = DefaultShares
+	}
+	retry := ls.RetryAfter
+	if retry <= 0 {
+		retry = DefaultRetryAfter
+	}
+	retryAfter := strconv.FormatInt(int64((retry+time.Second-1)/time.Second), 10)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prio := PriorityNormal
+		if ls.PriorityFunc != nil {
+			prio = ls.PriorityFunc(r)
+		}
+		share, ok := shares[prio]
+		if !ok {
+			share = 1
+		}
+
+		ls.mu.Lock()
+		if float64(ls.inFlight) >= math.Max(1, math.Floor(ls.limit*share)) {
+			ls.mu.Unlock()
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		ls.inFlight++
+		inFlight := ls.inFlight
+		ls.mu.Unlock()
+
+		start := time.Now()
+		defer func() {
+			rtt := time.Since(start)
+			err := r.Context().Err()
+			dropped := err == context.DeadlineExceeded
+
+			ls.mu.Lock()
+			ls.inFlight--
+			// a canceled request does not tell anything about the latency
+			if err != context.Canceled {
+				ls.limit = ls.alg.Update(ls.limit, rtt, inFlight, dropped)
+				ls.limit = math.Max(ls.min, math.Min(ls.max, ls.limit))
+			}
+			ls.mu.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadshed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedLimit is an Algorithm that never changes the limit.
+type fixedLimit struct{}
+
+func (fixedLimit) Update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	return limit
+}
+
+func TestLoadShedPriority(t *testing.T) {
+	prios := map[string]Priority{
+		"/low":      PriorityLow,
+		"/normal":   PriorityNormal,
+		"/high":     PriorityHigh,
+		"/critical": PriorityCritical,
+	}
+	ls := &LoadShed{
+		Algorithm:    fixedLimit{},
+		InitialLimit: 10,
+		PriorityFunc: func(r *http.Request) Priority { return prios[r.URL.Path] },
+		RetryAfter:   2 * time.Second,
+	}
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	h := httpmw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}), ls)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", path, nil)
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	cases := []struct {
+		path     string
+		inFlight int
+	}{
+		// low uses up to 5, normal 8, high 9 and critical 10
+		{"/low", 5},
+		{"/normal", 8},
+		{"/high", 9},
+		{"/critical", 10},
+	}
+	for _, c := range cases {
+		for ls.InFlight() < c.inFlight {
+			wg.Add(1)
+			go func(p string) {
+				defer wg.Done()
+				serve(p)
+			}(c.path)
+			<-started
+		}
+		w := serve(c.path)
+		assert.Equal(t, 503, w.Code, "%s: status", c.path)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"), "%s: retry after", c.path)
+	}
+	assert.Equal(t, 10, ls.Limit(), "limit")
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 0, ls.InFlight(), "in flight")
+}
+
+func TestLoadShedAdapt(t *testing.T) {
+	var sleep time.Duration
+	ls := &LoadShed{
+		Algorithm:    &AIMD{Threshold: 5 * time.Millisecond, Backoff: 0.5},
+		InitialLimit: 8,
+		MinLimit:     2,
+	}
+	h := httpmw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(sleep)
+	}), ls)
+	serve := func() {
+		r, _ := http.NewRequest("", "/", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// slow requests: decreases to the minimum
+	sleep = 10 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		serve()
+	}
+	assert.Equal(t, 2, ls.Limit(), "decreased")
+
+	// fast requests: increases only while the limit is used, 1 request
+	// in flight uses a limit of 2
+	sleep = 0
+	for i := 0; i < 10; i++ {
+		serve()
+	}
+	assert.Equal(t, 3, ls.Limit(), "increased")
+}
+
+func TestLoadShedDefaults(t *testing.T) {
+	assert.Equal(t, DefaultInitialLimit, (&LoadShed{}).Limit(), "default")
+	assert.Equal(t, DefaultMaxLimit, (&LoadShed{InitialLimit: 5000}).Limit(), "max")
+	assert.Equal(t, 3, (&LoadShed{InitialLimit: 2, MinLimit: 3}).Limit(), "min")
+}
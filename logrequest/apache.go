@@ -0,0 +1,236 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Apache-style log formats, as supported by LogRequest.Format.
+const (
+	// CommonLogFormat is the NCSA Common Log Format (CLF).
+	CommonLogFormat = `%h %l %u %t "%r" %>s %b`
+
+	// CombinedLogFormat is the NCSA Combined Log Format, the Common Log
+	// Format with the referer and user agent.
+	CombinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"`
+)
+
+// directive writes a part of the log line for the entry e.
+type directive func(buf *bytes.Buffer, e *entry)
+
+// parseFormat parses the Apache-style log format string, using the
+// redactor rd. Unsupported directives are written as-is.
+func parseFormat(format string, rd *redactor) []directive {
+	var dirs []directive
+	literal := func(s string) directive {
+		return func(buf *bytes.Buffer, e *entry) { buf.WriteString(s) }
+	}
+
+	for {
+		i := strings.IndexByte(format, '%')
+		if i < 0 || i == len(format)-1 {
+			break
+		}
+		if i > 0 {
+			dirs = append(dirs, literal(format[:i]))
+		}
+		spec := format[i:]
+
+		// %{param}X directives
+		var param string
+		rest := spec[1:]
+		if rest[0] == '{' {
+			if j := strings.IndexByte(rest, '}'); j > 0 && j < len(rest)-1 {
+				param, rest = rest[1:j], rest[j+1:]
+			}
+		}
+		// %>s is the final status, which is the only status available
+		if strings.HasPrefix(rest, ">") && len(rest) > 1 {
+			rest = rest[1:]
+		}
+
+		dir := formatDirective(rest[0], param, rd)
+		if dir == nil {
+			dir = literal(spec[:len(spec)-len(rest)+1])
+		}
+		dirs = append(dirs, dir)
+		format = rest[1:]
+	}
+	if format != "" {
+		dirs = append(dirs, literal(format))
+	}
+	return dirs
+}
+
+// formatDirective returns the directive for the Apache format character c,
+// or nil if it is not supported.
+func formatDirective(c byte, param string, rd *redactor) directive {
+	switch c {
+	case '%':
+		return func(buf *bytes.Buffer, e *entry) { buf.WriteByte('%') }
+	case 'a', 'h':
+		return func(buf *bytes.Buffer, e *entry) { buf.WriteString(remoteHost(e.r)) }
+	case 'l':
+		return func(buf *bytes.Buffer, e *entry) { buf.WriteByte('-') }
+	case 'u':
+		return func(buf *bytes.Buffer, e *entry) {
+			if u, _, ok := e.r.BasicAuth(); ok && u != "" {
+				writeEscaped(buf, u)
+				return
+			}
+			buf.WriteByte('-')
+		}
+	case 't':
+		return func(buf *bytes.Buffer, e *entry) {
+			buf.WriteByte('[')
+			buf.WriteString(e.start.Format("02/Jan/2006:15:04:05 -0700"))
+			buf.WriteByte(']')
+		}
+	case 'r':
+		return func(buf *bytes.Buffer, e *entry) {
+			writeEscaped(buf, e.r.Method)
+			buf.WriteByte(' ')
+			writeEscaped(buf, rd.uri(e.r.RequestURI))
+			buf.WriteByte(' ')
+			writeEscaped(buf, e.r.Proto)
+		}
+	case 'm':
+		return func(buf *bytes.Buffer, e *entry) { writeEscaped(buf, e.r.Method) }
+	case 'U':
+		return func(buf *bytes.Buffer, e *entry) { writeEscaped(buf, rd.path(e.r.URL.Path)) }
+	case 'q':
+		return func(buf *bytes.Buffer, e *entry) {
+			if e.r.URL.RawQuery != "" {
+				buf.WriteByte('?')
+				writeEscaped(buf, rd.rawQuery(e.r.URL.RawQuery))
+			}
+		}
+	case 'H':
+		return func(buf *bytes.Buffer, e *entry) { writeEscaped(buf, e.r.Proto) }
+	case 'v', 'V':
+		return func(buf *bytes.Buffer, e *entry) { writeEscaped(buf, e.r.Host) }
+	case 's':
+		return func(buf *bytes.Buffer, e *entry) {
+			if st, ok := e.status(); ok {
+				buf.WriteString(strconv.Itoa(st))
+				return
+			}
+			buf.WriteByte('-')
+		}
+	case 'b':
+		return func(buf *bytes.Buffer, e *entry) {
+			if sz, ok := e.size(); ok && sz > 0 {
+				buf.WriteString(strconv.Itoa(sz))
+				return
+			}
+			buf.WriteByte('-')
+		}
+	case 'B':
+		return func(buf *bytes.Buffer, e *entry) {
+			sz, _ := e.size()
+			buf.WriteString(strconv.Itoa(sz))
+		}
+	case 'D':
+		return func(buf *bytes.Buffer, e *entry) {
+			buf.WriteString(strconv.FormatInt(int64(e.end.Sub(e.start)/time.Microsecond), 10))
+		}
+	case 'T':
+		return func(buf *bytes.Buffer, e *entry) {
+			buf.WriteString(strconv.FormatInt(int64(e.end.Sub(e.start)/time.Second), 10))
+		}
+	case 'i':
+		if param == "" {
+			return nil
+		}
+		return func(buf *bytes.Buffer, e *entry) { writeHeader(buf, rd.header(e.r.Header, param)) }
+	case 'o':
+		if param == "" {
+			return nil
+		}
+		return func(buf *bytes.Buffer, e *entry) { writeHeader(buf, rd.header(e.w.Header(), param)) }
+	}
+	return nil
+}
+
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func writeHeader(buf *bytes.Buffer, v string) {
+	if v != "" {
+		writeEscaped(buf, v)
+		return
+	}
+	buf.WriteByte('-')
+}
+
+// writeEscaped writes s to buf, escaping the quotes, backslashes and
+// non-printable characters as done by Apache.
+func writeEscaped(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			buf.WriteString(`\x`)
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// wrapFormat returns the handler that writes the requests to lr.Writer
+// in the Apache-style format.
+func (lr *LogRequest) wrapFormat(h http.Handler) http.Handler {
+	format := lr.Format
+	if format == "" {
+		format = CommonLogFormat
+	}
+	dirs := parseFormat(format, lr.redactor())
+	out := lr.Writer
+	hd := lr.RequestIDHeader
+	if hd == "" {
+		hd = "X-Request-Id"
+	}
+	pol := lr.policy(hd)
+
+	var mu sync.Mutex
+	bufPool := sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		e := &entry{w: w, r: r, start: start, end: time.Now()}
+		if !pol.shouldLog(e) {
+			return
+		}
+
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		for _, dir := range dirs {
+			dir(buf, e)
+		}
+		buf.WriteByte('\n')
+
+		mu.Lock()
+		out.Write(buf.Bytes())
+		mu.Unlock()
+		bufPool.Put(buf)
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/PuerkitoBio/httpmw/augmentedrw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequestFormat(t *testing.T) {
+	const tm = `\[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\]`
+
+	cases := []struct {
+		format string
+		user   string
+		body   string
+		want   string
+	}{
+		{"", "", "hello", `^1\.2\.3\.4 - - ` + tm + ` "GET /a\?b=c HTTP/1\.1" 201 5$`},
+		{CommonLogFormat, "martin", "", `^1\.2\.3\.4 - martin ` + tm + ` "GET /a\?b=c HTTP/1\.1" 201 -$`},
+		{CombinedLogFormat, "", "", `^1\.2\.3\.4 - - ` + tm + ` "GET /a\?b=c HTTP/1\.1" 201 - "http://ref/\\"x\\"" "agent\\x01"$`},
+		{`%m %U%q %H %v %B %{X-Out}o %{X-None}i %% %D %T`, "", "", `^GET /a\?b=c HTTP/1\.1 example\.com 0 out - % \d+ 0$`},
+		{`%z %{X}z 100%`, "", "", `^%z %\{X\}z 100%$`},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		lr := &LogRequest{Writer: &buf, Format: c.format}
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Out", "out")
+			w.WriteHeader(201)
+			fmt.Fprint(w, c.body)
+		})
+		h := httpmw.Wrap(fn, httpmw.WrapperFunc(augmentedrw.Wrap), lr)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com/a?b=c", nil)
+		r.RequestURI = "/a?b=c"
+		r.RemoteAddr = "1.2.3.4:5678"
+		r.Header.Set("Referer", `http://ref/"x"`)
+		r.Header.Set("User-Agent", "agent\x01")
+		if c.user != "" {
+			r.SetBasicAuth(c.user, "pwd")
+		}
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, 201, w.Code, "%d: status", i)
+		out := buf.String()
+		if assert.True(t, len(out) > 0 && out[len(out)-1] == '\n', "%d: newline", i) {
+			assert.Regexp(t, regexp.MustCompile(c.want), out[:len(out)-1], "%d: output", i)
+		}
+	}
+}
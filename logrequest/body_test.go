@@ -0,0 +1,89 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/PuerkitoBio/httpmw/augmentedrw"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequestBodyBytesReceived(t *testing.T) {
+	cases := []struct {
+		body string
+		read int64
+		want string
+	}{
+		{"", -1, "body_bytes_received=0\n"},
+		{"hello", -1, "body_bytes_received=5\n"},
+		{"hello", 2, "body_bytes_received=2\n"},
+		{"hello", 0, "body_bytes_received=0\n"},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		lr := &LogRequest{Logger: log.NewLogfmtLogger(&buf), Fields: []string{"body_bytes_received"}}
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.read < 0 {
+				ioutil.ReadAll(r.Body)
+			} else {
+				io.CopyN(ioutil.Discard, r.Body, c.read)
+			}
+		})
+		h := httpmw.Wrap(fn, lr)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(c.body))
+		// simulate a chunked upload
+		r.ContentLength = -1
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, c.want, buf.String(), "%d: output", i)
+	}
+}
+
+func TestLogRequestStart(t *testing.T) {
+	var buf bytes.Buffer
+	var atStart string
+	lr := &LogRequest{
+		Logger:       log.NewLogfmtLogger(&buf),
+		LogStart:     true,
+		ExcludePaths: []string{"/healthz"},
+		Fields:       []string{"request_id", "path", "status", "resp_header:X-Out", "req_header:X-In", "duration"},
+	}
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atStart = buf.String()
+		w.Header().Set("X-Out", "out")
+		w.WriteHeader(201)
+	})
+	h := httpmw.Wrap(fn, httpmw.WrapperFunc(augmentedrw.Wrap), lr)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/a", nil)
+	r.Header.Set("X-Request-Id", "abcd")
+	r.Header.Set("X-In", "in")
+	h.ServeHTTP(w, r)
+
+	start := `msg="request started" request_id=abcd path=/a req_header:X-In=in` + "\n"
+	assert.Equal(t, start, atStart, "logged before handler")
+	lines := strings.SplitAfter(buf.String(), "\n")
+	if assert.Equal(t, 3, len(lines), "lines") {
+		assert.Equal(t, start, lines[0], "start line")
+		assert.Contains(t, lines[1], "request_id=abcd path=/a status=201 resp_header:X-Out=out req_header:X-In=in duration=", "end line")
+	}
+
+	buf.Reset()
+	r, _ = http.NewRequest("", "/healthz", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "", buf.String(), "excluded")
+}
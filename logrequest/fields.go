@@ -0,0 +1,189 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FieldFunc returns the value of a field to log for the request r and
+// its response writer w, called once the handler has returned.
+type FieldFunc func(w http.ResponseWriter, r *http.Request) string
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]FieldFunc)
+)
+
+// RegisterField registers a custom field that can be used in the Fields of
+// a LogRequest. The fields are resolved when the LogRequest's Wrap is
+// called, so it must be registered before that. It panics if the name is
+// empty, is one of the built-in fields or if fn is nil. A field registered
+// twice with the same name replaces the previous one.
+func RegisterField(name string, fn FieldFunc) {
+	if name == "" || fn == nil {
+		panic("logrequest: invalid custom field")
+	}
+	if _, ok := builtinFields[name]; ok {
+		panic("logrequest: cannot replace built-in field " + name)
+	}
+
+	registryMu.Lock()
+	registry[name] = fn
+	registryMu.Unlock()
+}
+
+// field is a compiled field to log.
+type field struct {
+	name string
+	fn   func(e *entry) string
+}
+
+// builtinFields holds the functions of the built-in fields. The fields
+// that depend on the configuration have a nil function, it is set by
+// compileFields.
+var builtinFields = map[string]func(e *entry) string{
+	"body_bytes_received": func(e *entry) string {
+		if e.body != nil {
+			return strconv.FormatInt(e.body.n, 10)
+		}
+		return "0"
+	},
+	"body_bytes_sent": func(e *entry) string {
+		if sz, ok := e.size(); ok {
+			return strconv.Itoa(sz)
+		}
+		return ""
+	},
+	"duration":    nil,
+	"end":         nil,
+	"host":        func(e *entry) string { return e.r.Host },
+	"method":      func(e *entry) string { return e.r.Method },
+	"origin":      nil,
+	"path":        nil,
+	"proto":       func(e *entry) string { return e.r.Proto },
+	"query":       nil,
+	"remote_addr": func(e *entry) string { return e.r.RemoteAddr },
+	"request_id":  nil,
+	"start":       nil,
+	"status": func(e *entry) string {
+		if st, ok := e.status(); ok {
+			return strconv.Itoa(st)
+		}
+		return ""
+	},
+	"uri":        nil,
+	"user_agent": nil,
+}
+
+// compileFields returns the compiled fields for the list of field names,
+// using the time format tf, duration format dfmt, request ID header hd and
+// redactor rd. Unknown fields are logged with an empty value.
+func compileFields(names []string, tf, dfmt, hd string, rd *redactor) []field {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	fields := make([]field, 0, len(names))
+	for _, name := range names {
+		fn := builtinFields[name]
+		switch name {
+		case "duration":
+			fn = func(e *entry) string { return fmt.Sprintf(dfmt, e.end.Sub(e.start).Seconds()) }
+		case "end":
+			fn = func(e *entry) string { return e.end.Format(tf) }
+		case "origin":
+			fn = func(e *entry) string { return rd.header(e.r.Header, "Origin") }
+		case "path":
+			fn = func(e *entry) string { return rd.path(e.r.URL.Path) }
+		case "query":
+			fn = func(e *entry) string { return rd.rawQuery(e.r.URL.RawQuery) }
+		case "request_id":
+			fn = func(e *entry) string { return e.r.Header.Get(hd) }
+		case "start":
+			fn = func(e *entry) string { return e.start.Format(tf) }
+		case "uri":
+			fn = func(e *entry) string { return rd.uri(e.r.RequestURI) }
+		case "user_agent":
+			fn = func(e *entry) string { return rd.header(e.r.Header, "User-Agent") }
+		}
+
+		if fn == nil {
+			fn = prefixField(name, rd)
+		}
+		if fn == nil {
+			if custom := registry[name]; custom != nil {
+				fn = func(e *entry) string { return custom(e.w, e.r) }
+			}
+		}
+		if fn == nil {
+			fn = func(e *entry) string { return "" }
+		}
+		fields = append(fields, field{name: name, fn: fn})
+	}
+	return fields
+}
+
+// responseFields is the set of built-in fields that depend on the response,
+// so that they are not logged on the request started line.
+var responseFields = map[string]bool{
+	"body_bytes_received": true,
+	"body_bytes_sent":     true,
+	"duration":            true,
+	"end":                 true,
+	"status":              true,
+}
+
+// startFields returns the field names of names that only depend on the
+// request. Custom fields are called once the handler has returned, so they
+// are not part of it.
+func startFields(names []string) []string {
+	var start []string
+	for _, name := range names {
+		if _, ok := builtinFields[name]; ok {
+			if !responseFields[name] {
+				start = append(start, name)
+			}
+			continue
+		}
+		if i := strings.Index(name, ":"); i > 0 && name[:i] != "resp_header" {
+			start = append(start, name)
+		}
+	}
+	return start
+}
+
+// prefixField returns the function for a field in the form prefix:arg, or
+// nil if name is not such a field.
+func prefixField(name string, rd *redactor) func(e *entry) string {
+	i := strings.Index(name, ":")
+	if i < 0 || i == len(name)-1 {
+		return nil
+	}
+
+	arg := name[i+1:]
+	switch name[:i] {
+	case "req_header":
+		return func(e *entry) string { return rd.header(e.r.Header, arg) }
+	case "resp_header":
+		return func(e *entry) string { return rd.header(e.w.Header(), arg) }
+	case "cookie":
+		return func(e *entry) string {
+			_, err := e.r.Cookie(arg)
+			return strconv.FormatBool(err == nil)
+		}
+	case "ctx":
+		return func(e *entry) string {
+			if v := e.r.Context().Value(arg); v != nil {
+				return fmt.Sprint(v)
+			}
+			return ""
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequestFields(t *testing.T) {
+	RegisterField("tenant_upper", func(w http.ResponseWriter, r *http.Request) string {
+		return "T-" + r.Header.Get("X-Tenant")
+	})
+
+	var buf bytes.Buffer
+	l := log.NewLogfmtLogger(&buf)
+	lr := &LogRequest{Logger: l, Fields: []string{
+		"req_header:X-Tenant",
+		"resp_header:Content-Type",
+		"cookie:session",
+		"cookie:other",
+		"ctx:tenant",
+		"ctx:none",
+		"tenant_upper",
+		"unknown",
+		"req_header:",
+		"status",
+	}}
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(200)
+	})
+	ctx := httpmw.WrapperFunc(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "tenant", 42)))
+		})
+	})
+	h := httpmw.Wrap(fn, ctx, lr)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "secret"})
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 200, w.Code, "status")
+	assert.Equal(t, `req_header:X-Tenant=acme resp_header:Content-Type=text/plain cookie:session=true cookie:other=false ctx:tenant=42 ctx:none= tenant_upper=T-acme unknown= req_header:= status=`+"\n", buf.String(), "expected output")
+}
+
+func TestRegisterFieldInvalid(t *testing.T) {
+	fn := func(w http.ResponseWriter, r *http.Request) string { return "" }
+	assert.Panics(t, func() { RegisterField("", fn) }, "empty name")
+	assert.Panics(t, func() { RegisterField("x", nil) }, "nil func")
+	assert.Panics(t, func() { RegisterField("status", fn) }, "built-in")
+	assert.Panics(t, func() { RegisterField("start", fn) }, "built-in")
+}
@@ -6,9 +6,9 @@
 package logrequest
 
 import (
-	"fmt"
+	"io"
 	"net/http"
-	"strconv"
+	"regexp"
 	"time"
 
 	"github.com/PuerkitoBio/httpmw"
@@ -35,7 +35,10 @@ var allFields = []string{
 
 // LogRequest holds the configuration for the LogRequest middleware.
 type LogRequest struct {
-	// Logger is the logger to use to log the requests.
+	// Logger is the logger to use to log the requests. It is called
+	// synchronously once the handler has returned, use an
+	// httpmw.AsyncLogger so that a slow logger does not delay the
+	// responses.
 	Logger httpmw.Logger
 
 	// RequestIDHeader is the name of the header that contains the request
@@ -54,7 +57,7 @@ type LogRequest struct {
 	// Fields is the list of field names to log. Defaults to all supported
 	// fields. The supported fields are:
 	//
-	//     body_bytes_received: bytes in the request body
+	//     body_bytes_received: bytes read from the request body
 	//     body_bytes_sent: bytes in the response body
 	//     duration: duration of the request
 	//     end: date and time of the end of the request (UTC)
@@ -71,13 +74,119 @@ type LogRequest struct {
 	//     uri: raw request URI
 	//     user_agent: value of the User-Agent request header
 	//
+	// Additional fields are supported with a prefix and an argument:
+	//
+	//     req_header:Name: value of the Name request header
+	//     resp_header:Name: value of the Name response header
+	//     cookie:name: "true" if the name cookie is present, "false" otherwise
+	//     ctx:key: value of the request's context for the string key
+	//
+	// The context value is the one of the request received by the
+	// LogRequest middleware, so it must be set by a middleware that runs
+	// before it. Custom fields can be added with RegisterField.
 	Fields []string
+
+	// SampleRate is the fraction of requests to log, between 0 and 1, e.g.
+	// 0.01 to log 1 request out of 100. If the request has a request ID,
+	// the decision is derived from it so that a given request is either
+	// logged or not in every service that uses the same SampleRate.
+	// Defaults to 0, which logs all requests, as does 1.
+	SampleRate float64
+
+	// AlwaysLogStatus is the status code threshold at or above which
+	// requests are always logged, regardless of the SampleRate and
+	// ExcludePaths (e.g. 500 to always log server errors). Disabled
+	// if <= 0.
+	AlwaysLogStatus int
+
+	// AlwaysLogDuration is the duration threshold at or above which
+	// requests are always logged, regardless of the SampleRate and
+	// ExcludePaths. Disabled if <= 0.
+	AlwaysLogDuration time.Duration
+
+	// ExcludePaths is the list of paths that are not logged, e.g.
+	// "/healthz". If a path ends with a slash, all paths that start
+	// with it are excluded.
+	ExcludePaths []string
+
+	// RedactQuery is the list of query parameter names whose values are
+	// redacted in the uri and query fields (and the %r and %q directives).
+	// The names are case-insensitive. Defaults to DefaultRedactQuery, set
+	// it to an empty, non-nil slice to disable query redaction.
+	RedactQuery []string
+
+	// RedactHeaders is the list of header names whose values are redacted
+	// in the header fields (and the %{Name}i and %{Name}o directives).
+	// Defaults to DefaultRedactHeaders, set it to an empty, non-nil slice
+	// to disable header redaction.
+	RedactHeaders []string
+
+	// RedactPaths is the list of regular expressions that match sensitive
+	// parts of the path in the uri and path fields (and the %r and %U
+	// directives), e.g. `^/reset/([^/]+)` to redact a password reset token.
+	// If a regular expression has capturing groups, only the groups are
+	// redacted, otherwise the whole match is.
+	RedactPaths []*regexp.Regexp
+
+	// Redactor returns the value to log in place of a redacted value v.
+	// Defaults to RedactMask, use RedactHash to log a hash of the value
+	// instead.
+	Redactor func(v string) string
+
+	// LogStart logs an additional line when the request is received, before
+	// calling the handler, so that long-running or hanging requests are
+	// visible in the logs. That line starts with msg="request started" and
+	// has the Fields that only depend on the request (e.g. not the status,
+	// duration, response headers or custom fields), so the request_id field
+	// should be in the Fields to correlate it with the final line. It is
+	// subject to the ExcludePaths and SampleRate, but not to the AlwaysLog
+	// thresholds. It is ignored if Writer is set.
+	LogStart bool
+
+	// Writer is the writer to use to write the requests in the Apache-style
+	// Format, e.g. for log analyzers that expect the Common or Combined
+	// Log Format. If it is set, the requests are written to Writer instead
+	// of being logged to the Logger, and the Fields, TimeFormat and
+	// DurationFormat are ignored.
+	Writer io.Writer
+
+	// Format is the Apache-style log format to use to write the requests
+	// to Writer. Defaults to CommonLogFormat. The supported directives are:
+	//
+	//     %%: a literal percent sign
+	//     %a, %h: remote address of the client, without the port
+	//     %b: body bytes sent, "-" if none
+	//     %B: body bytes sent
+	//     %D: duration of the request in microseconds
+	//     %H: protocol and version (e.g. HTTP/1.1)
+	//     %{Name}i: value of the Name request header
+	//     %l: always "-"
+	//     %m: method of the request
+	//     %{Name}o: value of the Name response header
+	//     %q: query string, with the leading "?" (empty if none)
+	//     %r: request line (method, request URI and protocol)
+	//     %s, %>s: status code of the response
+	//     %t: time of the start of the request
+	//     %T: duration of the request in seconds
+	//     %u: user of the basic authentication, "-" if none
+	//     %U: path section of the request URL
+	//     %v, %V: host of the request
+	//
+	// As for the status and body bytes fields, the response writer must
+	// record the status and size of the response (see package augmentedrw).
+	Format string
 }
 
 // Wrap returns a handler that records the start time, calls the handler h,
 // records the end time and duration, and logs the request's fields as
-// configured by the LogRequest.
+// configured by the LogRequest, if the request must be logged according
+// to the sampling and exclusion settings. If Writer is set, the request is written
+// to Writer in the Apache-style Format instead.
 func (lr *LogRequest) Wrap(h http.Handler) http.Handler {
+	if lr.Writer != nil {
+		return lr.wrapFormat(h)
+	}
+
 	log := lr.Logger
 	if log == nil {
 		return h
@@ -99,42 +208,69 @@ func (lr *LogRequest) Wrap(h http.Handler) http.Handler {
 		fields = allFields
 	}
 
+	rd := lr.redactor()
+	compiled := compileFields(fields, tf, dfmt, hd, rd)
+	var startCompiled []field
+	if lr.LogStart {
+		startCompiled = compileFields(startFields(fields), tf, dfmt, hd, rd)
+	}
+	pol := lr.policy(hd)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now().UTC()
-		h.ServeHTTP(w, r)
-		end := time.Now().UTC()
-
-		vals := map[string]string{
-			"start":               start.Format(tf),
-			"end":                 end.Format(tf),
-			"duration":            fmt.Sprintf(dfmt, end.Sub(start).Seconds()),
-			"proto":               r.Proto,
-			"host":                r.Host,
-			"method":              r.Method,
-			"uri":                 r.RequestURI,
-			"request_id":          r.Header.Get(hd),
-			"path":                r.URL.Path,
-			"origin":              r.Header.Get("Origin"),
-			"body_bytes_received": strconv.FormatInt(r.ContentLength, 10),
-			"user_agent":          r.UserAgent(),
-			"remote_addr":         r.RemoteAddr,
-			"query":               r.URL.RawQuery,
+		e := &entry{w: w, r: r, start: time.Now().UTC()}
+		if r.Body != nil {
+			e.body = &countingBody{ReadCloser: r.Body}
+			r.Body = e.body
 		}
-		if ww, ok := w.(interface {
-			Status() int
-		}); ok {
-			vals["status"] = strconv.Itoa(ww.Status())
-		}
-		if ww, ok := w.(interface {
-			Size() int
-		}); ok {
-			vals["body_bytes_sent"] = strconv.Itoa(ww.Size())
+
+		sampled := pol.sampled(r)
+		if sampled && lr.LogStart {
+			log.Log(e.args(startCompiled, "msg", "request started")...)
 		}
 
-		args := make([]interface{}, 0, len(fields)*2)
-		for _, f := range fields {
-			args = append(args, f, vals[f])
+		h.ServeHTTP(w, r)
+		e.end = time.Now().UTC()
+		if !sampled && !pol.always(e) {
+			return
 		}
-		log.Log(args...)
+		log.Log(e.args(compiled)...)
 	})
 }
+
+// entry holds the data of a request to log.
+type entry struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	start time.Time
+	end   time.Time
+	body  *countingBody
+}
+
+// args returns the key-value pairs of the fields for the entry, after
+// the leading pairs in prefix.
+func (e *entry) args(fields []field, prefix ...interface{}) []interface{} {
+	args := make([]interface{}, 0, len(prefix)+len(fields)*2)
+	args = append(args, prefix...)
+	for _, f := range fields {
+		args = append(args, f.name, f.fn(e))
+	}
+	return args
+}
+
+func (e *entry) status() (int, bool) {
+	if ww, ok := e.w.(interface {
+		Status() int
+	}); ok {
+		return ww.Status(), true
+	}
+	return 0, false
+}
+
+func (e *entry) size() (int, bool) {
+	if ww, ok := e.w.(interface {
+		Size() int
+	}); ok {
+		return ww.Size(), true
+	}
+	return 0, false
+}
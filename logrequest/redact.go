@@ -0,0 +1,176 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactQuery is the list of query parameter names whose values
+// are redacted by default.
+var DefaultRedactQuery = []string{
+	"access_token",
+	"api_key",
+	"apikey",
+	"client_secret",
+	"code",
+	"id_token",
+	"key",
+	"password",
+	"refresh_token",
+	"secret",
+	"sig",
+	"signature",
+	"token",
+}
+
+// DefaultRedactHeaders is the list of header names whose values are
+// redacted by default.
+var DefaultRedactHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// RedactMask is a redaction function that replaces any value with
+// "REDACTED".
+func RedactMask(v string) string {
+	return "REDACTED"
+}
+
+// RedactHash is a redaction function that replaces a value with a prefix
+// of its SHA-256 hash, so that identical values can be correlated in
+// the logs without being disclosed.
+func RedactHash(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// redactor redacts the sensitive parts of the logged values.
+type redactor struct {
+	query   map[string]bool
+	headers map[string]bool
+	paths   []*regexp.Regexp
+	fn      func(string) string
+}
+
+func (lr *LogRequest) redactor() *redactor {
+	rd := &redactor{
+		query:   make(map[string]bool),
+		headers: make(map[string]bool),
+		paths:   lr.RedactPaths,
+		fn:      lr.Redactor,
+	}
+	if rd.fn == nil {
+		rd.fn = RedactMask
+	}
+
+	query := lr.RedactQuery
+	if query == nil {
+		query = DefaultRedactQuery
+	}
+	for _, q := range query {
+		rd.query[strings.ToLower(q)] = true
+	}
+	headers := lr.RedactHeaders
+	if headers == nil {
+		headers = DefaultRedactHeaders
+	}
+	for _, h := range headers {
+		rd.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return rd
+}
+
+// header returns the value of the header k in h, redacted if required.
+func (rd *redactor) header(h http.Header, k string) string {
+	v := h.Get(k)
+	if v != "" && rd.headers[http.CanonicalHeaderKey(k)] {
+		return rd.fn(v)
+	}
+	return v
+}
+
+// path returns the path p with the matches of the RedactPaths redacted.
+// If a regular expression has capturing groups, only the groups are
+// redacted, otherwise the whole match is.
+func (rd *redactor) path(p string) string {
+	for _, re := range rd.paths {
+		matches := re.FindAllStringSubmatchIndex(p, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var buf []byte
+		last := 0
+		for _, m := range matches {
+			groups := m[2:]
+			if len(groups) == 0 {
+				groups = m[:2]
+			}
+			for i := 0; i < len(groups); i += 2 {
+				start, end := groups[i], groups[i+1]
+				if start < last || start == end {
+					// unmatched or nested group
+					continue
+				}
+				buf = append(buf, p[last:start]...)
+				buf = append(buf, rd.fn(p[start:end])...)
+				last = end
+			}
+		}
+		buf = append(buf, p[last:]...)
+		p = string(buf)
+	}
+	return p
+}
+
+// rawQuery returns the raw query string q with the values of the RedactQuery
+// parameters redacted.
+func (rd *redactor) rawQuery(q string) string {
+	if q == "" || len(rd.query) == 0 {
+		return q
+	}
+
+	parts := strings.Split(q, "&")
+	for i, part := range parts {
+		eq := strings.Index(part, "=")
+		if eq < 0 || eq == len(part)-1 {
+			continue
+		}
+		k, err := url.QueryUnescape(part[:eq])
+		if err != nil {
+			k = part[:eq]
+		}
+		if rd.query[strings.ToLower(k)] {
+			v, err := url.QueryUnescape(part[eq+1:])
+			if err != nil {
+				v = part[eq+1:]
+			}
+			parts[i] = part[:eq+1] + url.QueryEscape(rd.fn(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uri returns the request URI u with its path and query redacted.
+func (rd *redactor) uri(u string) string {
+	p, q := u, ""
+	if i := strings.Index(u, "?"); i >= 0 {
+		p, q = u[:i], u[i+1:]
+	}
+	p = rd.path(p)
+	if q != "" {
+		return p + "?" + rd.rawQuery(q)
+	}
+	return p
+}
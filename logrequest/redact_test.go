@@ -0,0 +1,98 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	cases := []struct {
+		lr    *LogRequest
+		uri   string
+		path  string
+		query string
+	}{
+		{&LogRequest{}, "/a?b=c&token=x", "/a", "b=c&token=REDACTED"},
+		{&LogRequest{}, "/a?Access_Token=x&api%5Fkey=y&token=&code", "/a", "Access_Token=REDACTED&api%5Fkey=REDACTED&token=&code"},
+		{&LogRequest{RedactQuery: []string{}}, "/a?token=x", "/a", "token=x"},
+		{&LogRequest{RedactQuery: []string{"b"}}, "/a?b=c&token=x", "/a", "b=REDACTED&token=x"},
+		{&LogRequest{RedactPaths: []*regexp.Regexp{regexp.MustCompile(`^/reset/[^/]+`)}}, "/reset/abc/x", "REDACTED/x", ""},
+		{&LogRequest{RedactPaths: []*regexp.Regexp{regexp.MustCompile(`/users/([^/]+)/keys/([^/]+)`)}}, "/users/1/keys/2?q=1", "/users/REDACTED/keys/REDACTED", "q=1"},
+		{&LogRequest{RedactPaths: []*regexp.Regexp{regexp.MustCompile(`/t/(\w+)`)}}, "/t/a/t/b", "/t/REDACTED/t/REDACTED", ""},
+		{&LogRequest{Redactor: RedactHash}, "/a?token=x", "/a", "token=" + url.QueryEscape(RedactHash("x"))},
+	}
+	for i, c := range cases {
+		rd := c.lr.redactor()
+		r, _ := http.NewRequest("GET", c.uri, nil)
+		r.RequestURI = c.uri
+
+		assert.Equal(t, c.path, rd.path(r.URL.Path), "%d: path", i)
+		assert.Equal(t, c.query, rd.rawQuery(r.URL.RawQuery), "%d: query", i)
+		want := c.path
+		if c.query != "" {
+			want += "?" + c.query
+		}
+		assert.Equal(t, want, rd.uri(r.RequestURI), "%d: uri", i)
+	}
+}
+
+func TestRedactHash(t *testing.T) {
+	assert.Equal(t, RedactHash("a"), RedactHash("a"), "same value")
+	assert.NotEqual(t, RedactHash("a"), RedactHash("b"), "different values")
+	assert.Regexp(t, `^sha256:[0-9a-f]{16}$`, RedactHash("a"), "format")
+}
+
+func TestLogRequestRedact(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(200)
+	})
+
+	cases := []struct {
+		lr   *LogRequest
+		want string
+	}{
+		{
+			&LogRequest{Fields: []string{"uri", "req_header:Authorization", "resp_header:Set-Cookie", "req_header:X-Other"}},
+			`uri="/a?password=REDACTED" req_header:Authorization=REDACTED resp_header:Set-Cookie=REDACTED req_header:X-Other=x` + "\n",
+		},
+		{
+			&LogRequest{Fields: []string{"user_agent", "req_header:Authorization"}, RedactHeaders: []string{"user-agent"}},
+			`user_agent=REDACTED req_header:Authorization=secret` + "\n",
+		},
+		{
+			&LogRequest{Format: `"%r" %U%q %{Authorization}i %{Set-Cookie}o`},
+			`"GET /a?password=REDACTED HTTP/1.1" /a?password=REDACTED REDACTED REDACTED` + "\n",
+		},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		if c.lr.Format != "" {
+			c.lr.Writer = &buf
+		} else {
+			c.lr.Logger = log.NewLogfmtLogger(&buf)
+		}
+		h := c.lr.Wrap(fn)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/a?password=pwd", nil)
+		r.RequestURI = "/a?password=pwd"
+		r.Header.Set("Authorization", "secret")
+		r.Header.Set("User-Agent", "agent")
+		r.Header.Set("X-Other", "x")
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, c.want, buf.String(), "%d: output", i)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// policy decides which requests are logged.
+type policy struct {
+	rate        float64
+	minStatus   int
+	minDuration time.Duration
+	exclude     []string
+	reqIDHeader string
+}
+
+func (lr *LogRequest) policy(reqIDHeader string) *policy {
+	return &policy{
+		rate:        lr.SampleRate,
+		minStatus:   lr.AlwaysLogStatus,
+		minDuration: lr.AlwaysLogDuration,
+		exclude:     lr.ExcludePaths,
+		reqIDHeader: reqIDHeader,
+	}
+}
+
+// shouldLog returns true if the request of the entry e must be logged.
+func (p *policy) shouldLog(e *entry) bool {
+	return p.always(e) || p.sampled(e.r)
+}
+
+// always returns true if the request of the entry e must be logged
+// regardless of the exclusions and sampling.
+func (p *policy) always(e *entry) bool {
+	if p.minStatus > 0 {
+		if st, ok := e.status(); ok && st >= p.minStatus {
+			return true
+		}
+	}
+	return p.minDuration > 0 && e.end.Sub(e.start) >= p.minDuration
+}
+
+// sampled returns true if the request r is not excluded and is part of
+// the sample. It only depends on the request, so it can be called before
+// the handler.
+func (p *policy) sampled(r *http.Request) bool {
+	for _, pfx := range p.exclude {
+		if r.URL.Path == pfx || (strings.HasSuffix(pfx, "/") && strings.HasPrefix(r.URL.Path, pfx)) {
+			return false
+		}
+	}
+
+	if p.rate <= 0 || p.rate >= 1 {
+		return true
+	}
+	if id := r.Header.Get(p.reqIDHeader); id != "" {
+		return sampleKey(id) < p.rate
+	}
+	return rand.Float64() < p.rate
+}
+
+// sampleKey returns a value in [0, 1) derived from the key, so that the
+// same key is always sampled the same way.
+func sampleKey(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logrequest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/PuerkitoBio/httpmw/augmentedrw"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequestPolicy(t *testing.T) {
+	cases := []struct {
+		lr     LogRequest
+		path   string
+		status int
+		sleep  time.Duration
+		want   bool
+	}{
+		{LogRequest{}, "/", 200, 0, true},
+		{LogRequest{SampleRate: 1}, "/", 200, 0, true},
+		{LogRequest{ExcludePaths: []string{"/healthz"}}, "/healthz", 200, 0, false},
+		{LogRequest{ExcludePaths: []string{"/healthz"}}, "/healthz/x", 200, 0, true},
+		{LogRequest{ExcludePaths: []string{"/static/"}}, "/static/x", 200, 0, false},
+		{LogRequest{ExcludePaths: []string{"/healthz"}, AlwaysLogStatus: 500}, "/healthz", 503, 0, true},
+		{LogRequest{ExcludePaths: []string{"/healthz"}, AlwaysLogStatus: 500}, "/healthz", 404, 0, false},
+		{LogRequest{SampleRate: 0.0000001}, "/", 200, 0, false},
+		{LogRequest{SampleRate: 0.0000001, AlwaysLogStatus: 400}, "/", 400, 0, true},
+		{LogRequest{SampleRate: 0.0000001, AlwaysLogDuration: 10 * time.Millisecond}, "/", 200, 0, false},
+		{LogRequest{SampleRate: 0.0000001, AlwaysLogDuration: 10 * time.Millisecond}, "/", 200, 20 * time.Millisecond, true},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		for j, lr := range []LogRequest{c.lr, c.lr} {
+			// second run uses the Apache-style format
+			if j == 1 {
+				lr.Writer = &buf
+			} else {
+				lr.Logger = log.NewLogfmtLogger(&buf)
+			}
+			buf.Reset()
+
+			fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(c.sleep)
+				w.WriteHeader(c.status)
+			})
+			h := httpmw.Wrap(fn, httpmw.WrapperFunc(augmentedrw.Wrap), &lr)
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("", c.path, nil)
+			r.Header.Set("X-Request-Id", "abcd")
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, c.want, buf.Len() > 0, "%d-%d: logged", i, j)
+		}
+	}
+}
+
+func TestLogRequestSampleRate(t *testing.T) {
+	var buf bytes.Buffer
+	lr := &LogRequest{Logger: log.NewLogfmtLogger(&buf), SampleRate: 0.25, Fields: []string{"request_id"}}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), lr)
+
+	const n = 10000
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id-%d", i)
+	}
+
+	run := func() string {
+		buf.Reset()
+		for _, id := range ids {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("", "/", nil)
+			r.Header.Set("X-Request-Id", id)
+			h.ServeHTTP(w, r)
+		}
+		return buf.String()
+	}
+
+	first := run()
+	count := strings.Count(first, "\n")
+	assert.InDelta(t, n/4, count, n/20, "sampled count")
+	assert.Equal(t, first, run(), "deterministic sampling")
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"net/http"
+
+	"github.com/PuerkitoBio/httpmw/remoteip"
+)
+
+// CostFunc returns the cost of the request r, which is the number of
+// units of the quota it uses.
+type CostFunc func(r *http.Request) int64
+
+// PathCosts returns a CostFunc that returns the cost of the request's
+// path in costs, or 1 if the path is not in costs.
+func PathCosts(costs map[string]int64) CostFunc {
+	return func(r *http.Request) int64 {
+		if n, ok := costs[r.URL.Path]; ok {
+			return n
+		}
+		return 1
+	}
+}
+
+// allowlist returns a function that returns true if the request bypasses
+// the rate limiter, because its remote IP address is in the Allowlist or
+// the AllowFunc returns true. It returns nil if there is no allowlist.
+func (rl *RateLimit) allowlist() func(r *http.Request) bool {
+	nets, err := remoteip.ParseNets(rl.Allowlist)
+	if err != nil {
+		panic("ratelimit: " + err.Error())
+	}
+	if len(nets) == 0 && rl.AllowFunc == nil {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		if nets.Contains(r.RemoteAddr) {
+			return true
+		}
+		return rl.AllowFunc != nil && rl.AllowFunc(r)
+	}
+}
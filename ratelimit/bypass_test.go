@@ -0,0 +1,97 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitCost(t *testing.T) {
+	rl := &RateLimit{RPS: 1, Capacity: 10, CostFunc: PathCosts(map[string]int64{"/export": 5, "/free": 0})}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	cases := []struct {
+		path      string
+		want      int
+		remaining string
+	}{
+		{"/export", 200, "5"},
+		{"/ping", 200, "4"},
+		{"/export", 429, "4"},
+		{"/free", 200, ""},
+		{"/ping", 200, "3"},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", c.path, nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.want, w.Code, "%d: status", i)
+		assert.Equal(t, c.remaining, w.Header().Get("RateLimit-Remaining"), "%d: remaining", i)
+	}
+}
+
+func TestRateLimitCostAboveCapacity(t *testing.T) {
+	advance := setClock(t, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	rl := &RateLimit{RPS: 10, CostFunc: PathCosts(map[string]int64{"/export": 50})}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	cases := []struct {
+		advance time.Duration
+		want    int
+		retry   string
+	}{
+		{0, 200, ""},
+		{0, 429, "1"},
+		{500 * time.Millisecond, 429, "1"},
+		{500 * time.Millisecond, 200, ""},
+	}
+	for i, c := range cases {
+		advance(c.advance)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/export", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.want, w.Code, "%d: status", i)
+		assert.Equal(t, c.retry, w.Header().Get("Retry-After"), "%d: retry after", i)
+	}
+}
+
+func TestRateLimitAllowlist(t *testing.T) {
+	rl := &RateLimit{
+		RPS:       1,
+		Allowlist: []string{"10.0.0.0/8", "::1"},
+		AllowFunc: func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+	}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	cases := []struct {
+		addr string
+		path string
+		want int
+	}{
+		{"1.2.3.4:1", "/", 200},
+		{"1.2.3.4:1", "/", 429},
+		{"10.1.2.3:1", "/", 200},
+		{"10.1.2.3:1", "/", 200},
+		{"[::1]:1", "/", 200},
+		{"1.2.3.4:1", "/healthz", 200},
+		{"invalid", "/", 429},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", c.path, nil)
+		r.RemoteAddr = c.addr
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.want, w.Code, "%d: status", i)
+	}
+
+	assert.Panics(t, func() { (&RateLimit{RPS: 1, Allowlist: []string{"x"}}).Wrap(h) }, "invalid IP")
+	assert.Panics(t, func() { (&RateLimit{RPS: 1, Allowlist: []string{"1.2.3.4/99"}}).Wrap(h) }, "invalid CIDR")
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxCASAttempts is the maximum number of attempts to update the state of
+// a GCRA key before denying the request under contention.
+const maxCASAttempts = 10
+
+// GCRA is a Limiter that implements the generic cell rate algorithm. It
+// allows Limit requests per Period, evenly spaced, with bursts of up to
+// Burst requests. It is equivalent to a token bucket, but its state is a
+// single timestamp per key (the theoretical arrival time of the next
+// request), updated with a compare-and-swap, so that it can be kept in a
+// shared Store. Under heavy contention on a key, a request may be denied
+// if its state cannot be updated.
+type GCRA struct {
+	// Limit is the number of requests allowed per Period. It must be > 0.
+	Limit int64
+
+	// Period is the period of the Limit. It must be > 0.
+	Period time.Duration
+
+	// Burst is the maximum number of requests allowed at once. Defaults to
+	// the Limit.
+	Burst int64
+
+	// Store is the store of the state of the keys. Defaults to a
+	// MemoryStore with MaxKeys.
+	Store Store
+
+	// MaxKeys is the maximum number of keys of the default Store. Defaults
+	// to DefaultMaxKeys.
+	MaxKeys int
+
+	once  sync.Once
+	err   error
+	burst int64
+	st    Store
+}
+
+// Validate returns an error if the configuration is invalid.
+func (g *GCRA) Validate() error {
+	if g.Limit <= 0 || g.Period <= 0 {
+		return errors.New("ratelimit: GCRA limit and period must be > 0")
+	}
+	return nil
+}
+
+func (g *GCRA) init() {
+	if g.err = g.Validate(); g.err != nil {
+		return
+	}
+	g.burst = g.Burst
+	if g.burst <= 0 {
+		g.burst = g.Limit
+	}
+	g.st = g.Store
+	if g.st == nil {
+		g.st = &MemoryStore{MaxKeys: g.MaxKeys}
+	}
+}
+
+// Allow implements Limiter for the GCRA. It returns an error if the
+// configuration is invalid.
+func (g *GCRA) Allow(key string, n int64) (Result, error) {
+	g.once.Do(g.init)
+	if g.err != nil {
+		return Result{}, g.err
+	}
+
+	interval := float64(g.Period) / float64(g.Limit) // emission interval
+	tolerance := time.Duration(interval * float64(g.burst))
+	res := Result{Limit: g.burst, Window: tolerance}
+	n = capCost(n, g.burst)
+
+	for i := 0; i < maxCASAttempts; i++ {
+		now := timeNow()
+		stored, err := g.st.Get(key)
+		if err != nil {
+			return Result{}, err
+		}
+
+		tat := time.Unix(0, stored)
+		if tat.Before(now) {
+			tat = now
+		}
+		newTAT := tat.Add(time.Duration(interval * float64(n)))
+		if allowAt := newTAT.Add(-tolerance); now.Before(allowAt) {
+			res.RetryAfter = allowAt.Sub(now)
+			res.Reset = tat.Sub(now)
+			res.Remaining = g.remaining(tolerance-res.Reset, interval)
+			return res, nil
+		}
+
+		ok, err := g.st.CompareAndSwap(key, stored, newTAT.UnixNano(), newTAT.Sub(now))
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			res.Allowed = true
+			res.Reset = newTAT.Sub(now)
+			res.Remaining = g.remaining(tolerance-res.Reset, interval)
+			return res, nil
+		}
+	}
+
+	// other requests for the key are being allowed concurrently
+	res.RetryAfter = time.Duration(interval * float64(n))
+	return res, nil
+}
+
+// remaining returns the number of requests that fit in the available
+// part d of the tolerance.
+func (g *GCRA) remaining(d time.Duration, interval float64) int64 {
+	// tolerate rounding errors of the durations
+	return int64(math.Max(0, math.Floor(float64(d)/interval+1e-6)))
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setHeaders sets the rate limit headers of the result res on w, using
+// the legacy X-RateLimit-* headers if legacy is true.
+func setHeaders(w http.ResponseWriter, res Result, legacy bool) {
+	hdr := w.Header()
+	if legacy {
+		hdr.Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+		hdr.Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+		hdr.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(res.Reset).Unix(), 10))
+	} else {
+		window := ceilSeconds(res.Window)
+		if window < 1 {
+			window = 1
+		}
+		hdr.Set("RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+		hdr.Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+		hdr.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+		hdr.Set("RateLimit-Policy", strconv.FormatInt(res.Limit, 10)+";w="+strconv.FormatInt(window, 10))
+	}
+
+	if !res.Allowed {
+		retry := ceilSeconds(res.RetryAfter)
+		if retry < 1 {
+			retry = 1
+		}
+		hdr.Set("Retry-After", strconv.FormatInt(retry, 10))
+	}
+}
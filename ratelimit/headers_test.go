@@ -0,0 +1,75 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	rl := &RateLimit{RPS: 1, Capacity: 2}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	cases := []struct {
+		status    int
+		remaining string
+		reset     string
+		retry     string
+	}{
+		{200, "1", "1", ""},
+		{200, "0", "2", ""},
+		{429, "0", "2", "1"},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/", nil)
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, c.status, w.Code, "%d: status", i)
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"), "%d: limit", i)
+		assert.Equal(t, c.remaining, w.Header().Get("RateLimit-Remaining"), "%d: remaining", i)
+		assert.Equal(t, c.reset, w.Header().Get("RateLimit-Reset"), "%d: reset", i)
+		assert.Equal(t, "2;w=2", w.Header().Get("RateLimit-Policy"), "%d: policy", i)
+		assert.Equal(t, c.retry, w.Header().Get("Retry-After"), "%d: retry after", i)
+		assert.Equal(t, "", w.Header().Get("X-RateLimit-Limit"), "%d: legacy limit", i)
+	}
+}
+
+func TestRateLimitLegacyHeaders(t *testing.T) {
+	rl := &RateLimit{RPS: 10, LegacyHeaders: true}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"), "limit")
+	assert.Equal(t, "9", w.Header().Get("X-RateLimit-Remaining"), "remaining")
+	reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if assert.NoError(t, err, "reset") {
+		assert.InDelta(t, time.Now().Unix(), reset, 1, "reset time")
+	}
+	assert.Equal(t, "", w.Header().Get("RateLimit-Limit"), "standard limit")
+}
+
+func TestRateLimitDisableHeaders(t *testing.T) {
+	rl := &RateLimit{RPS: 1, DisableHeaders: true}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	for _, want := range []int{200, 429} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, want, w.Code, "status")
+		assert.Equal(t, 0, len(w.Header()["RateLimit-Limit"])+len(w.Header()["Retry-After"]), "no headers")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+)
+
+// KeyFunc returns the key that identifies the client of the request r,
+// e.g. its IP address. Each key is rate-limited separately.
+type KeyFunc func(r *http.Request) string
+
+// RemoteIPKey is a KeyFunc that returns the IP address of the request's
+// RemoteAddr, without the port. Use the remoteip middleware before the
+// RateLimit middleware to get the effective client IP address when behind
+// a proxy.
+func RemoteIPKey(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// HeaderKey returns a KeyFunc that returns the value of the header name,
+// e.g. an API key.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// UserKey is a KeyFunc that returns the user of the request's basic
+// authentication, e.g. as validated by the basicauth middleware.
+func UserKey(r *http.Request) string {
+	u, _, _ := r.BasicAuth()
+	return u
+}
@@ -0,0 +1,30 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFuncs(t *testing.T) {
+	r, _ := http.NewRequest("", "/", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	r.Header.Set("X-Api-Key", "abc")
+	r.SetBasicAuth("martin", "pwd")
+
+	assert.Equal(t, "1.2.3.4", RemoteIPKey(r), "remote IP")
+	assert.Equal(t, "abc", HeaderKey("X-Api-Key")(r), "header")
+	assert.Equal(t, "", HeaderKey("X-None")(r), "missing header")
+	assert.Equal(t, "martin", UserKey(r), "user")
+
+	// as set by the remoteip middleware
+	r.RemoteAddr = "::1"
+	assert.Equal(t, "::1", RemoteIPKey(r), "remote IP without port")
+	r.Header.Del("Authorization")
+	assert.Equal(t, "", UserKey(r), "no user")
+}
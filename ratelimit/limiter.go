@@ -0,0 +1,78 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// timeNow is the function that returns the current time, replaced in tests.
+var timeNow = time.Now
+
+// Result is the outcome of a rate limit decision.
+type Result struct {
+	// Allowed is true if the request is allowed.
+	Allowed bool
+
+	// Limit is the maximum number of requests in the Window.
+	Limit int64
+
+	// Remaining is the number of requests remaining in the current window.
+	Remaining int64
+
+	// Reset is the time until the quota is fully available again.
+	Reset time.Duration
+
+	// RetryAfter is the time until a denied request may be allowed.
+	RetryAfter time.Duration
+
+	// Window is the time window of the Limit.
+	Window time.Duration
+}
+
+// Limiter defines the Allow method that implements a rate limiting
+// algorithm.
+type Limiter interface {
+	// Allow takes n units of the quota of the key, if it is available, and
+	// returns the result of the decision. It must be safe for concurrent use.
+	//
+	// The limiters of this package cap n to the Limit of the Result, so
+	// that a request that costs more than the whole quota is allowed when
+	// the quota is fully available, and uses all of it.
+	Allow(key string, n int64) (Result, error)
+}
+
+// validator is implemented by the limiters that can check their
+// configuration, such as those of this package.
+type validator interface {
+	Validate() error
+}
+
+// secondsDuration returns the duration of s seconds.
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds returns d in seconds, rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// capCost returns the cost n, capped to the limit if the limit is > 0.
+func capCost(n, limit int64) int64 {
+	if limit > 0 && n > limit {
+		return limit
+	}
+	return n
+}
+
+// maxKeys returns n, or DefaultMaxKeys if n <= 0.
+func maxKeys(n int) int {
+	if n <= 0 {
+		return DefaultMaxKeys
+	}
+	return n
+}
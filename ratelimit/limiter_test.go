@@ -0,0 +1,154 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+// setClock replaces timeNow with a clock that starts at start and returns
+// a function to advance it.
+func setClock(t *testing.T, start time.Time) func(time.Duration) {
+	var mu sync.Mutex
+	now := start
+	timeNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+	return func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+}
+
+// step is a call to Allow after advancing the clock.
+type step struct {
+	advance   time.Duration
+	n         int64
+	allowed   bool
+	remaining int64
+	retry     time.Duration
+}
+
+func runSteps(t *testing.T, name string, lim Limiter, steps []step) {
+	advance := setClock(t, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	for i, s := range steps {
+		advance(s.advance)
+		n := s.n
+		if n == 0 {
+			n = 1
+		}
+		res, err := lim.Allow("k", n)
+		if assert.NoError(t, err, "%s %d: error", name, i) {
+			assert.Equal(t, s.allowed, res.Allowed, "%s %d: allowed", name, i)
+			assert.Equal(t, s.remaining, res.Remaining, "%s %d: remaining", name, i)
+			assert.Equal(t, s.retry, res.RetryAfter, "%s %d: retry after", name, i)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	runSteps(t, "token bucket", &TokenBucket{Rate: 2, Capacity: 3}, []step{
+		{0, 1, true, 2, 0},
+		{0, 2, true, 0, 0},
+		{0, 1, false, 0, 500 * time.Millisecond},
+		{500 * time.Millisecond, 1, true, 0, 0},
+		// capped to the capacity
+		{0, 4, false, 0, 1500 * time.Millisecond},
+		{10 * time.Second, 1, true, 2, 0},
+	})
+}
+
+func TestFixedWindow(t *testing.T) {
+	runSteps(t, "fixed window", &FixedWindow{Limit: 3, Window: time.Minute}, []step{
+		{0, 1, true, 2, 0},
+		{10 * time.Second, 2, true, 0, 0},
+		{20 * time.Second, 1, false, 0, 30 * time.Second},
+		{30 * time.Second, 3, true, 0, 0},
+		{0, 1, false, 0, time.Minute},
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	runSteps(t, "sliding window", &SlidingWindow{Limit: 4, Window: time.Minute}, []step{
+		{0, 4, true, 0, 0},
+		// needs the previous window to weigh 3/4, 15s in the next window
+		{30 * time.Second, 1, false, 0, 45 * time.Second},
+		{45 * time.Second, 1, true, 0, 0},
+		// previous window weighs 2/3, needs it to weigh 1/2
+		{5 * time.Second, 1, false, 0, 10 * time.Second},
+		{10 * time.Second, 1, true, 0, 0},
+		{2 * time.Minute, 1, true, 3, 0},
+	})
+}
+
+func TestGCRA(t *testing.T) {
+	runSteps(t, "gcra", &GCRA{Limit: 60, Period: time.Minute, Burst: 3}, []step{
+		{0, 1, true, 2, 0},
+		{0, 2, true, 0, 0},
+		{0, 1, false, 0, time.Second},
+		{time.Second, 1, true, 0, 0},
+		{2 * time.Second, 1, true, 1, 0},
+		{time.Minute, 1, true, 2, 0},
+	})
+}
+
+func TestLimiterCostAboveLimit(t *testing.T) {
+	cases := []struct {
+		name string
+		lim  Limiter
+	}{
+		{"token bucket", &TokenBucket{Rate: 1, Capacity: 3}},
+		{"fixed window", &FixedWindow{Limit: 3, Window: time.Minute}},
+		{"sliding window", &SlidingWindow{Limit: 3, Window: time.Minute}},
+		{"gcra", &GCRA{Limit: 1, Period: time.Second, Burst: 3}},
+	}
+	for _, c := range cases {
+		advance := setClock(t, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+
+		res, err := c.lim.Allow("k", 10)
+		assert.NoError(t, err, "%s: error", c.name)
+		assert.True(t, res.Allowed, "%s: full quota", c.name)
+		assert.Equal(t, int64(0), res.Remaining, "%s: remaining", c.name)
+
+		res, _ = c.lim.Allow("k", 10)
+		assert.False(t, res.Allowed, "%s: empty quota", c.name)
+		assert.True(t, res.RetryAfter > 0, "%s: retry after", c.name)
+
+		advance(res.RetryAfter)
+		res, _ = c.lim.Allow("k", 10)
+		assert.True(t, res.Allowed, "%s: after retry after", c.name)
+	}
+}
+
+func TestLimiterInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		lim  Limiter
+	}{
+		{"token bucket", &TokenBucket{}},
+		{"fixed window", &FixedWindow{Limit: 1}},
+		{"sliding window", &SlidingWindow{Limit: 1}},
+		{"gcra", &GCRA{Limit: 1}},
+	}
+	for _, c := range cases {
+		assert.Error(t, c.lim.(validator).Validate(), "%s: validate", c.name)
+		// the error is returned on every call
+		for i := 0; i < 2; i++ {
+			_, err := c.lim.Allow("", 1)
+			assert.Error(t, err, "%s %d: allow", c.name, i)
+		}
+		assert.Panics(t, func() { (&RateLimit{Limiter: c.lim}).Wrap(httpmw.StatusHandler(200)) }, "%s: wrap", c.name)
+	}
+	assert.Panics(t, func() { (&RateLimit{}).Wrap(httpmw.StatusHandler(200)) }, "no RPS")
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a map of keys to values bounded in size, that evicts the least
+// recently used keys when it is full, the keys that have not been used
+// for the idle duration and the keys that are expired. It is safe for
+// concurrent use.
+type lru struct {
+	max  int
+	idle time.Duration
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // front is the most recently used
+}
+
+type lruItem struct {
+	key     string
+	val     interface{}
+	used    time.Time
+	expires time.Time // zero if it never expires
+}
+
+func newLRU(max int, idle time.Duration) *lru {
+	return &lru{
+		max:   max,
+		idle:  idle,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// get returns the value of key, or nil if it does not exist or is
+// expired. It does not mark the key as used.
+func (c *lru) get(key string, now time.Time) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		if it := el.Value.(*lruItem); !it.expired(now) {
+			return it.val
+		}
+	}
+	return nil
+}
+
+// update calls fn with the value of key, or nil if it does not exist or
+// is expired, and stores the value returned by fn, which expires at the
+// returned time (never if it is zero). If fn returns nil, the key is
+// removed. It returns the stored value. fn is called with the lru locked,
+// so the update is atomic.
+func (c *lru) update(key string, now time.Time, fn func(v interface{}) (interface{}, time.Time)) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictIdle(now)
+	el, ok := c.items[key]
+	if ok {
+		it := el.Value.(*lruItem)
+		old := it.val
+		if it.expired(now) {
+			old = nil
+		}
+		it.val, it.expires = fn(old)
+		if it.val == nil {
+			c.remove(el)
+			return nil
+		}
+		it.used = now
+		c.order.MoveToFront(el)
+		return it.val
+	}
+
+	it := &lruItem{key: key, used: now}
+	if it.val, it.expires = fn(nil); it.val == nil {
+		return nil
+	}
+	if c.max > 0 && c.order.Len() >= c.max {
+		c.remove(c.order.Back())
+	}
+	c.items[key] = c.order.PushFront(it)
+	return it.val
+}
+
+// len returns the number of keys.
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// evictIdle removes the keys that have not been used for the idle duration.
+func (c *lru) evictIdle(now time.Time) {
+	if c.idle <= 0 {
+		return
+	}
+	for el := c.order.Back(); el != nil; el = c.order.Back() {
+		if now.Sub(el.Value.(*lruItem).used) < c.idle {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (it *lruItem) expired(now time.Time) bool {
+	return !it.expires.IsZero() && !now.Before(it.expires)
+}
+
+func (c *lru) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruItem).key)
+}
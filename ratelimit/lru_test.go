@@ -0,0 +1,66 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Now()
+	var created int
+	get := func(c *lru, key string) interface{} {
+		return c.update(key, now, func(v interface{}) (interface{}, time.Time) {
+			if v == nil {
+				created++
+				return created, time.Time{}
+			}
+			return v, time.Time{}
+		})
+	}
+
+	c := newLRU(2, time.Minute)
+	assert.Equal(t, 1, get(c, "a"), "a created")
+	assert.Equal(t, 2, get(c, "b"), "b created")
+	assert.Equal(t, 1, get(c, "a"), "a exists")
+
+	// b is the least recently used
+	assert.Equal(t, 3, get(c, "c"), "c created")
+	assert.Equal(t, 2, c.len(), "bounded")
+	assert.Equal(t, 1, get(c, "a"), "a kept")
+	assert.Equal(t, 4, get(c, "b"), "b evicted")
+
+	// c was evicted by b, then a becomes idle
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, 4, get(c, "b"), "b exists")
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, 4, get(c, "b"), "b kept")
+	assert.Equal(t, 1, c.len(), "a evicted")
+	assert.Nil(t, c.get("a", now), "a missing")
+}
+
+func TestLRUExpires(t *testing.T) {
+	now := time.Now()
+	c := newLRU(0, 0)
+	set := func(v interface{}, exp time.Time) interface{} {
+		return c.update("a", now, func(interface{}) (interface{}, time.Time) { return v, exp })
+	}
+
+	set(1, now.Add(time.Second))
+	assert.Equal(t, 1, c.get("a", now), "not expired")
+	assert.Nil(t, c.get("a", now.Add(time.Second)), "expired")
+
+	now = now.Add(time.Second)
+	assert.Nil(t, c.update("a", now, func(v interface{}) (interface{}, time.Time) {
+		assert.Nil(t, v, "expired value")
+		return nil, time.Time{}
+	}), "removed")
+	assert.Equal(t, 0, c.len(), "empty")
+	assert.Nil(t, set(nil, time.Time{}), "not created")
+	assert.Equal(t, 0, c.len(), "still empty")
+}
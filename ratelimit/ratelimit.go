@@ -3,15 +3,14 @@
 // license that can be found in the LICENSE file.
 
 // Package ratelimit implements a rate limiter middleware handler.
-// It uses the github.com/juju/ratelimit package to control the rate.
-// This package uses a token bucket implementation.
+// The rate limiting algorithm is pluggable via the Limiter interface, and
+// the package provides token bucket, fixed window, sliding window and
+// GCRA implementations. It uses a token bucket by default.
 package ratelimit
 
 import (
 	"net/http"
 	"time"
-
-	"github.com/juju/ratelimit"
 )
 
 // RateLimit holds the configuration for the RateLimit middleware handler.
@@ -19,38 +18,157 @@ import (
 // to wait for an available token for the request to be allowed, set
 // RPS=100, MaxWait=50ms.
 type RateLimit struct {
+	// Limiter is the rate limiting algorithm to use. If it is nil, a
+	// TokenBucket configured with RPS, Capacity, MaxKeys and IdleTimeout
+	// is used.
+	Limiter Limiter
+
 	// RPS is the number of requests per seconds. Tokens will fill at an
-	// interval that closely respects that RPS value.
+	// interval that closely respects that RPS value. It is ignored if
+	// Limiter is set.
 	RPS int64
 
 	// Capacity is the maximum number of tokens that can be available in
 	// the bucket. The bucket starts at full capacity. If the capacity is
-	// <= 0, it is set to the RPS.
+	// <= 0, it is set to the RPS. It is ignored if Limiter is set.
 	Capacity int64
 
-	// MaxWait is the maximum time to wait for an available token for a
-	// request to be allowed. If no token is available, the request is
+	// MaxWait is the maximum time to wait for a request to be allowed. If
+	// the Limiter denies a request that would be allowed within MaxWait,
+	// the middleware waits and tries again once. Otherwise the request is
 	// denied without waiting and a status code 429 is returned.
+	//
+	// Nothing is reserved while the request waits, so under concurrent
+	// load another request may use the quota first, and the request that
+	// waited is then denied. The wait stops early, and the request is
+	// denied, if the request's context is done.
 	MaxWait time.Duration
+
+	// KeyFunc returns the key of the client of the request. Each key has
+	// its own bucket, so that a client does not use the tokens of the
+	// others. If it is nil, a single bucket is shared by all requests.
+	// Requests with an empty key share the same bucket.
+	KeyFunc KeyFunc
+
+	// MaxKeys is the maximum number of keys with a bucket. When it is
+	// reached, the bucket of the least recently used key is removed, so
+	// that memory usage stays bounded even if many distinct keys are
+	// used. Defaults to DefaultMaxKeys. It is ignored if Limiter is set.
+	MaxKeys int
+
+	// IdleTimeout is the duration after which the bucket of a key that is
+	// not used is removed. A removed bucket starts again at full capacity.
+	// Disabled if <= 0. It is ignored if Limiter is set.
+	IdleTimeout time.Duration
+
+	// DisableHeaders disables the rate limit headers. By default, the
+	// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+	// RateLimit-Policy headers are set on every response, as defined by
+	// the IETF RateLimit header fields draft, and the Retry-After header
+	// is set on denied requests.
+	DisableHeaders bool
+
+	// CostFunc returns the cost of the request, which is the number of
+	// units of the quota it uses, e.g. to make an expensive endpoint use
+	// more of the quota (see PathCosts). If it is nil, each request costs
+	// 1. A request with a cost <= 0 is not rate limited. A cost above the
+	// limit is capped to the limit (see Limiter), so such a request is
+	// allowed only when the whole quota is available.
+	CostFunc CostFunc
+
+	// Allowlist is the list of IP addresses or CIDR ranges of the clients
+	// that are never rate limited, e.g. internal services. Behind a
+	// reverse proxy, the client's address is only known if the remoteip
+	// middleware runs first. Wrap panics if an entry is invalid.
+	Allowlist []string
+
+	// AllowFunc returns true if the request must not be rate limited,
+	// e.g. for internal monitoring requests.
+	AllowFunc func(r *http.Request) bool
+
+	// LegacyHeaders uses the X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset headers instead of the standard ones. The
+	// X-RateLimit-Reset header is the Unix time in seconds at which the
+	// quota is fully reset.
+	LegacyHeaders bool
 }
 
+// DefaultMaxKeys is the default maximum number of keys with a bucket.
+const DefaultMaxKeys = 10000
+
 // Wrap returns a handler that allows only the configured number of requests.
 // The wrapped handler h is called only if the request is allowed by the rate
-// limiter, otherwise a status code 429 is returned.
+// limiter, otherwise a status code 429 is returned. The rate limit headers
+// are set before calling h. If the Limiter fails, the request is allowed,
+// so that a failure of the rate limiter does not make the handler fail.
+// The requests that bypass the rate limiter (see CostFunc, Allowlist and
+// AllowFunc) have no rate limit headers.
 //
-// Each call to Wrap creates a new, distinct rate limiter bucket that controls
-// access to h.
+// If Limiter is nil, each call to Wrap creates new, distinct rate limiter
+// buckets that control access to h. It panics if the configuration is
+// invalid, e.g. if RPS is <= 0 and Limiter is nil, or if the Limiter has a
+// Validate method that returns an error.
 func (rl *RateLimit) Wrap(h http.Handler) http.Handler {
-	cap := rl.Capacity
-	if rl.Capacity <= 0 {
-		cap = rl.RPS
+	lim := rl.Limiter
+	if lim == nil {
+		lim = &TokenBucket{
+			Rate:     float64(rl.RPS),
+			Capacity: rl.Capacity,
+			MaxKeys:  rl.MaxKeys,
+			idle:     rl.IdleTimeout,
+		}
 	}
-	bucket := ratelimit.NewBucketWithRate(float64(rl.RPS), cap)
+	if v, ok := lim.(validator); ok {
+		if err := v.Validate(); err != nil {
+			panic(err.Error())
+		}
+	}
+	bypass := rl.allowlist()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !bucket.WaitMaxDuration(1, rl.MaxWait) {
+		cost := int64(1)
+		if rl.CostFunc != nil {
+			cost = rl.CostFunc(r)
+		}
+		if cost <= 0 || (bypass != nil && bypass(r)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		var key string
+		if rl.KeyFunc != nil {
+			key = rl.KeyFunc(r)
+		}
+
+		res, err := lim.Allow(key, cost)
+		if err == nil && !res.Allowed && res.RetryAfter <= rl.MaxWait && wait(r, res.RetryAfter) {
+			res, err = lim.Allow(key, cost)
+		}
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if !rl.DisableHeaders {
+			setHeaders(w, res, rl.LegacyHeaders)
+		}
+		if !res.Allowed {
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
 		h.ServeHTTP(w, r)
 	})
 }
+
+// wait waits for the duration d, and returns false if the context of the
+// request r is done before.
+func wait(r *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -5,6 +5,8 @@
 package ratelimit
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -34,3 +36,79 @@ func TestRateLimit(t *testing.T) {
 		assert.Equal(t, want, w.Code, "status")
 	}
 }
+
+func TestRateLimitKey(t *testing.T) {
+	rl := &RateLimit{RPS: 1, Capacity: 1, KeyFunc: RemoteIPKey, MaxKeys: 2}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+	cases := []struct {
+		addr string
+		want int
+	}{
+		{"1.1.1.1:1", 200},
+		{"1.1.1.1:2", 429},
+		{"2.2.2.2:1", 200},
+		{"2.2.2.2:1", 429},
+		{"1.1.1.1:3", 429},
+		// evicts 2.2.2.2, the least recently used
+		{"3.3.3.3:1", 200},
+		{"1.1.1.1:3", 429},
+		{"2.2.2.2:1", 200},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/", nil)
+		r.RemoteAddr = c.addr
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.want, w.Code, "%d: status", i)
+	}
+}
+
+// limiterFunc adapts a function to a Limiter.
+type limiterFunc func(key string, n int64) (Result, error)
+
+func (fn limiterFunc) Allow(key string, n int64) (Result, error) { return fn(key, n) }
+
+func TestRateLimitLimiter(t *testing.T) {
+	var calls int
+	cases := []struct {
+		lim  Limiter
+		wait time.Duration
+		want int
+	}{
+		{&FixedWindow{Limit: 1, Window: time.Hour}, 0, 200},
+		{limiterFunc(func(string, int64) (Result, error) { return Result{}, errors.New("down") }), 0, 200},
+		{limiterFunc(func(string, int64) (Result, error) { return Result{RetryAfter: time.Second}, nil }), 0, 429},
+		{limiterFunc(func(string, int64) (Result, error) {
+			calls++
+			return Result{Allowed: calls > 1, RetryAfter: time.Millisecond}, nil
+		}), 10 * time.Millisecond, 200},
+	}
+	for i, c := range cases {
+		rl := &RateLimit{Limiter: c.lim, MaxWait: c.wait}
+		h := httpmw.Wrap(httpmw.StatusHandler(200), rl)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, c.want, w.Code, "%d: status", i)
+	}
+	assert.Equal(t, 2, calls, "retried after waiting")
+}
+
+func TestRateLimitWaitCanceled(t *testing.T) {
+	var calls int
+	lim := limiterFunc(func(string, int64) (Result, error) {
+		calls++
+		return Result{Limit: 1, RetryAfter: time.Hour}, nil
+	})
+	h := httpmw.Wrap(httpmw.StatusHandler(200), &RateLimit{Limiter: lim, MaxWait: 2 * time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+	h.ServeHTTP(w, r.WithContext(ctx))
+	assert.Equal(t, 429, w.Code, "status")
+	assert.Equal(t, 1, calls, "not retried")
+}
@@ -0,0 +1,356 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default configuration of the RedisStore.
+const (
+	DefaultRedisPrefix       = "ratelimit:"
+	DefaultRedisTimeout      = time.Second
+	DefaultRedisMaxIdleConns = 8
+)
+
+// RedisStore is a Store that keeps the state in a Redis server (or any
+// server that implements the Redis protocol, MULTI/EXEC and WATCH), so that
+// it can be shared by multiple instances of a service. It is safe for
+// concurrent use. The keys are stored as Redis strings.
+type RedisStore struct {
+	// Addr is the address of the server, e.g. "localhost:6379".
+	Addr string
+
+	// Password is the password to authenticate to the server. If it is
+	// empty, the connections are not authenticated.
+	Password string
+
+	// DB is the database number to select.
+	DB int
+
+	// Prefix is added to the keys. Defaults to DefaultRedisPrefix. Each
+	// Limiter using the same server should have a distinct prefix.
+	Prefix string
+
+	// Timeout is the timeout to connect to the server and to execute a
+	// command. Defaults to DefaultRedisTimeout.
+	Timeout time.Duration
+
+	// MaxIdleConns is the maximum number of idle connections to keep open.
+	// Defaults to DefaultRedisMaxIdleConns.
+	MaxIdleConns int
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// Incr implements Store for the RedisStore.
+func (s *RedisStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	var count int64
+	err := s.do(func(c *redisConn) error {
+		k := s.key(key)
+		rep, err := c.transaction(
+			[]string{"SET", k, "0", "PX", millis(ttl), "NX"},
+			[]string{"INCRBY", k, strconv.FormatInt(n, 10)},
+		)
+		if err != nil {
+			return err
+		}
+		if len(rep) != 2 {
+			return errors.New("ratelimit: unexpected redis reply")
+		}
+		count, err = replyInt(rep[1])
+		return err
+	})
+	return count, err
+}
+
+// Get implements Store for the RedisStore.
+func (s *RedisStore) Get(key string) (int64, error) {
+	var v int64
+	err := s.do(func(c *redisConn) error {
+		rep, err := c.command("GET", s.key(key))
+		if err != nil {
+			return err
+		}
+		v, err = replyInt(rep)
+		return err
+	})
+	return v, err
+}
+
+// CompareAndSwap implements Store for the RedisStore.
+func (s *RedisStore) CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := s.do(func(c *redisConn) error {
+		k := s.key(key)
+		if _, err := c.command("WATCH", k); err != nil {
+			return err
+		}
+		rep, err := c.command("GET", k)
+		if err != nil {
+			return err
+		}
+		cur, err := replyInt(rep)
+		if err != nil {
+			return err
+		}
+		if cur != old {
+			_, err := c.command("UNWATCH")
+			return err
+		}
+
+		res, err := c.transaction([]string{"SET", k, strconv.FormatInt(new, 10), "PX", millis(ttl)})
+		if err != nil {
+			return err
+		}
+		// a nil reply means that the key was modified
+		ok = res != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (s *RedisStore) key(key string) string {
+	if s.Prefix == "" {
+		return DefaultRedisPrefix + key
+	}
+	return s.Prefix + key
+}
+
+// do calls fn with a connection, that is closed if fn fails.
+func (s *RedisStore) do(fn func(c *redisConn) error) error {
+	c, err := s.conn()
+	if err != nil {
+		return err
+	}
+	if err := fn(c); err != nil {
+		c.Close()
+		return err
+	}
+	s.release(c)
+	return nil
+}
+
+func (s *RedisStore) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultRedisTimeout
+	}
+	return s.Timeout
+}
+
+func (s *RedisStore) conn() (*redisConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", s.Addr, s.timeout())
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: s.timeout()}
+	if s.Password != "" {
+		if _, err := c.command("AUTH", s.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.DB != 0 {
+		if _, err := c.command("SELECT", strconv.Itoa(s.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (s *RedisStore) release(c *redisConn) {
+	max := s.MaxIdleConns
+	if max <= 0 {
+		max = DefaultRedisMaxIdleConns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= max {
+		c.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+// Close closes the idle connections.
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for _, c := range s.idle {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.idle = nil
+	return err
+}
+
+func millis(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "ratelimit: redis: " + string(e) }
+
+// redisConn is a connection to a Redis server.
+type redisConn struct {
+	net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// command executes the command args and returns its reply.
+func (c *redisConn) command(args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(c.timeout))
+	c.write(args)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// transaction executes the commands in a MULTI/EXEC transaction and
+// returns their replies, or nil if the transaction was aborted because
+// of a WATCH.
+func (c *redisConn) transaction(cmds ...[]string) ([]interface{}, error) {
+	c.SetDeadline(time.Now().Add(c.timeout))
+	c.write([]string{"MULTI"})
+	for _, cmd := range cmds {
+		c.write(cmd)
+	}
+	c.write([]string{"EXEC"})
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// +OK for MULTI and +QUEUED for each command
+	for i := 0; i <= len(cmds); i++ {
+		if _, err := c.read(); err != nil {
+			return nil, err
+		}
+	}
+	rep, err := c.read()
+	if err != nil || rep == nil {
+		return nil, err
+	}
+	reps, ok := rep.([]interface{})
+	if !ok {
+		return nil, errors.New("ratelimit: unexpected redis reply")
+	}
+	for _, r := range reps {
+		if err, ok := r.(redisError); ok {
+			return nil, err
+		}
+	}
+	return reps, nil
+}
+
+// write writes the command args to the buffer, the errors are reported
+// when it is flushed.
+func (c *redisConn) write(args []string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// read reads a reply, which is a string, an int64, nil, a redisError or
+// a []interface{} of replies. An error reply is returned as error, except
+// in arrays.
+func (c *redisConn) read() (interface{}, error) {
+	rep, err := readReply(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := rep.(redisError); ok {
+		return nil, err
+	}
+	return rep, nil
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("ratelimit: invalid redis reply")
+	}
+	typ, line := line[0], line[1:len(line)-2]
+
+	switch typ {
+	case '+':
+		return line, nil
+	case '-':
+		return redisError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		reps := make([]interface{}, n)
+		for i := range reps {
+			if reps[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return reps, nil
+	}
+	return nil, errors.New("ratelimit: invalid redis reply")
+}
+
+// replyInt returns the integer value of the reply rep, which is 0 if rep
+// is nil.
+func replyInt(rep interface{}) (int64, error) {
+	switch v := rep.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, errors.New("ratelimit: unexpected redis reply")
+}
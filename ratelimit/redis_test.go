@@ -0,0 +1,286 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a minimal server that implements the subset of the Redis
+// protocol used by the RedisStore. Expiration uses timeNow.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	versions map[string]int
+	conns    int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &fakeRedis{
+		ln:       ln,
+		password: password,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.serve()
+	return srv
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.serveConn(c)
+	}
+}
+
+func (f *fakeRedis) serveConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+
+	authed := f.password == ""
+	var queue [][]string
+	var multi bool
+	watched := make(map[string]int)
+
+	for {
+		rep, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range rep.([]interface{}) {
+			args = append(args, a.(string))
+		}
+		cmd := strings.ToUpper(args[0])
+
+		switch {
+		case cmd == "AUTH":
+			if args[1] != f.password {
+				w.WriteString("-ERR invalid password\r\n")
+				break
+			}
+			authed = true
+			w.WriteString("+OK\r\n")
+		case !authed:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		case cmd == "MULTI":
+			multi = true
+			w.WriteString("+OK\r\n")
+		case cmd == "EXEC":
+			f.mu.Lock()
+			aborted := false
+			for k, v := range watched {
+				if f.versions[k] != v {
+					aborted = true
+				}
+			}
+			if aborted {
+				w.WriteString("*-1\r\n")
+			} else {
+				fmt.Fprintf(w, "*%d\r\n", len(queue))
+				for _, q := range queue {
+					w.WriteString(f.exec(q))
+				}
+			}
+			f.mu.Unlock()
+			queue, multi = nil, false
+			watched = make(map[string]int)
+		case cmd == "WATCH":
+			f.mu.Lock()
+			watched[args[1]] = f.versions[args[1]]
+			f.mu.Unlock()
+			w.WriteString("+OK\r\n")
+		case cmd == "UNWATCH":
+			watched = make(map[string]int)
+			w.WriteString("+OK\r\n")
+		case multi:
+			queue = append(queue, args)
+			w.WriteString("+QUEUED\r\n")
+		default:
+			f.mu.Lock()
+			w.WriteString(f.exec(args))
+			f.mu.Unlock()
+		}
+		w.Flush()
+	}
+}
+
+// exec executes the command args and returns its reply, f.mu must be held.
+func (f *fakeRedis) exec(args []string) string {
+	k := ""
+	if len(args) > 1 {
+		k = args[1]
+		if exp, ok := f.expires[k]; ok && !timeNow().Before(exp) {
+			delete(f.values, k)
+			delete(f.expires, k)
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.values[k]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		if _, ok := f.values[k]; ok && len(args) > 5 && strings.ToUpper(args[5]) == "NX" {
+			return "$-1\r\n"
+		}
+		ms, _ := strconv.Atoi(args[4])
+		f.values[k] = args[2]
+		f.expires[k] = timeNow().Add(time.Duration(ms) * time.Millisecond)
+		f.versions[k]++
+		return "+OK\r\n"
+	case "INCRBY":
+		cur, _ := strconv.ParseInt(f.values[k], 10, 64)
+		n, _ := strconv.ParseInt(args[2], 10, 64)
+		f.values[k] = strconv.FormatInt(cur+n, 10)
+		f.versions[k]++
+		return fmt.Sprintf(":%d\r\n", cur+n)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// testStore runs the tests common to all stores.
+func testStore(t *testing.T, st Store, advance func(time.Duration)) {
+	n, err := st.Incr("a", 2, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n, "incr new")
+	n, _ = st.Incr("a", 3, time.Hour)
+	assert.Equal(t, int64(5), n, "incr existing")
+	n, _ = st.Get("a")
+	assert.Equal(t, int64(5), n, "get")
+
+	advance(time.Second)
+	n, _ = st.Get("a")
+	assert.Equal(t, int64(0), n, "expired")
+
+	ok, _ := st.CompareAndSwap("b", 1, 2, time.Second)
+	assert.False(t, ok, "cas missing")
+	n, _ = st.Get("b")
+	assert.Equal(t, int64(0), n, "not created")
+	ok, _ = st.CompareAndSwap("b", 0, 2, time.Second)
+	assert.True(t, ok, "cas new")
+	ok, _ = st.CompareAndSwap("b", 0, 3, time.Second)
+	assert.False(t, ok, "cas mismatch")
+	ok, err = st.CompareAndSwap("b", 2, 3, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, ok, "cas match")
+	n, _ = st.Get("b")
+	assert.Equal(t, int64(3), n, "swapped")
+}
+
+func TestRedisStore(t *testing.T) {
+	advance := setClock(t, time.Now())
+	srv := newFakeRedis(t, "secret")
+	st := &RedisStore{Addr: srv.ln.Addr().String(), Password: "secret", DB: 2}
+	defer st.Close()
+
+	testStore(t, st, advance)
+	assert.Equal(t, 1, srv.conns, "connection reused")
+	_, ok := srv.values[DefaultRedisPrefix+"b"]
+	assert.True(t, ok, "prefixed key")
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	srv := newFakeRedis(t, "secret")
+	st := &RedisStore{Addr: srv.ln.Addr().String(), Password: "wrong"}
+	_, err := st.Get("a")
+	assert.Error(t, err, "invalid password")
+
+	st = &RedisStore{Addr: srv.ln.Addr().String()}
+	_, err = st.Incr("a", 1, time.Second)
+	assert.Error(t, err, "no password")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	st = &RedisStore{Addr: addr, Timeout: 100 * time.Millisecond}
+	_, err = st.Get("a")
+	assert.Error(t, err, "no server")
+}
+
+func TestRedisStoreShared(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	addr := srv.ln.Addr().String()
+
+	// two instances of a service, sharing the limits
+	cases := []struct {
+		name string
+		new  func(st Store) Limiter
+	}{
+		{"gcra", func(st Store) Limiter { return &GCRA{Limit: 10, Period: time.Hour, Store: st} }},
+		{"fixed window", func(st Store) Limiter { return &FixedWindow{Limit: 10, Window: time.Hour, Store: st} }},
+		{"sliding window", func(st Store) Limiter { return &SlidingWindow{Limit: 10, Window: time.Hour, Store: st} }},
+	}
+	for _, c := range cases {
+		prefix := strings.Replace(c.name, " ", "", -1) + ":"
+		st1, st2 := &RedisStore{Addr: addr, Prefix: prefix}, &RedisStore{Addr: addr, Prefix: prefix}
+		lims := []Limiter{c.new(st1), c.new(st2)}
+
+		var allowed int
+		for i := 0; i < 30; i++ {
+			res, err := lims[i%2].Allow("k", 1)
+			assert.NoError(t, err, "%s %d: allow", c.name, i)
+			if res.Allowed {
+				allowed++
+			}
+		}
+		assert.Equal(t, 10, allowed, "%s: allowed", c.name)
+		st1.Close()
+		st2.Close()
+	}
+}
+
+func TestRedisStoreConcurrent(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	st := &RedisStore{Addr: srv.ln.Addr().String()}
+	defer st.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := st.Incr("a", 1, time.Minute)
+			assert.NoError(t, err, "incr")
+		}()
+	}
+	wg.Wait()
+
+	n, err := st.Get("a")
+	assert.NoError(t, err, "get")
+	assert.Equal(t, int64(50), n, "count")
+	assert.True(t, len(st.idle) <= DefaultRedisMaxIdleConns, "idle connections bounded")
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store holds the state of the rate limiters, as integer values that
+// expire. A Store shared by multiple instances of a service (e.g. a
+// RedisStore) makes the limits apply to all instances together, the
+// clocks of the instances should then be synchronized.
+type Store interface {
+	// Incr atomically increments the value of key by n and returns the new
+	// value. If the key does not exist, it is created with the value n and
+	// expires after ttl.
+	Incr(key string, n int64, ttl time.Duration) (int64, error)
+
+	// Get returns the value of key, or 0 if it does not exist.
+	Get(key string) (int64, error)
+
+	// CompareAndSwap atomically sets the value of key to new, expiring after
+	// ttl, if its current value is old. A key that does not exist has the
+	// value 0. It returns true if the value was set.
+	CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error)
+}
+
+// MemoryStore is an in-memory Store, bounded in size. It is the default
+// Store of the limiters.
+type MemoryStore struct {
+	// MaxKeys is the maximum number of keys. When it is reached, the least
+	// recently used key is removed. Defaults to DefaultMaxKeys.
+	MaxKeys int
+
+	once  sync.Once
+	items *lru
+}
+
+type memValue struct {
+	n       int64
+	expires time.Time
+}
+
+func (s *MemoryStore) init() {
+	s.items = newLRU(maxKeys(s.MaxKeys), 0)
+}
+
+// Incr implements Store for the MemoryStore.
+func (s *MemoryStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	s.once.Do(s.init)
+
+	now := timeNow()
+	v := s.items.update(key, now, func(v interface{}) (interface{}, time.Time) {
+		if v == nil {
+			return memValue{n: n, expires: now.Add(ttl)}, now.Add(ttl)
+		}
+		mv := v.(memValue)
+		mv.n += n
+		return mv, mv.expires
+	})
+	return v.(memValue).n, nil
+}
+
+// Get implements Store for the MemoryStore.
+func (s *MemoryStore) Get(key string) (int64, error) {
+	s.once.Do(s.init)
+
+	if v := s.items.get(key, timeNow()); v != nil {
+		return v.(memValue).n, nil
+	}
+	return 0, nil
+}
+
+// CompareAndSwap implements Store for the MemoryStore.
+func (s *MemoryStore) CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error) {
+	s.once.Do(s.init)
+
+	now := timeNow()
+	var ok bool
+	s.items.update(key, now, func(v interface{}) (interface{}, time.Time) {
+		var cur memValue
+		if v != nil {
+			cur = v.(memValue)
+		}
+		if cur.n != old {
+			if v == nil {
+				return nil, time.Time{}
+			}
+			return cur, cur.expires
+		}
+		ok = true
+		return memValue{n: new, expires: now.Add(ttl)}, now.Add(ttl)
+	})
+	return ok, nil
+}
@@ -0,0 +1,15 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	advance := setClock(t, time.Now())
+	testStore(t, &MemoryStore{}, advance)
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a Limiter that implements the token bucket algorithm.
+// Each key has a bucket that starts full and fills with Rate tokens per
+// second, up to its Capacity. A request is allowed if there are enough
+// tokens in the bucket. It allows bursts of up to Capacity requests.
+//
+// The state of the buckets is kept in memory. Use a GCRA with a shared
+// Store for an equivalent limit across multiple instances of a service.
+type TokenBucket struct {
+	// Rate is the number of tokens added to the bucket per second. It must
+	// be > 0.
+	Rate float64
+
+	// Capacity is the maximum number of tokens in the bucket. Defaults to
+	// the Rate, rounded up.
+	Capacity int64
+
+	// MaxKeys is the maximum number of keys with a bucket. When it is
+	// reached, the bucket of the least recently used key is removed.
+	// Defaults to DefaultMaxKeys.
+	MaxKeys int
+
+	idle    time.Duration
+	once    sync.Once
+	err     error
+	cap     float64
+	buckets *lru
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Validate returns an error if the configuration is invalid.
+func (tb *TokenBucket) Validate() error {
+	if tb.Rate <= 0 {
+		return errors.New("ratelimit: TokenBucket rate must be > 0")
+	}
+	return nil
+}
+
+func (tb *TokenBucket) init() {
+	if tb.err = tb.Validate(); tb.err != nil {
+		return
+	}
+	tb.cap = float64(tb.Capacity)
+	if tb.Capacity <= 0 {
+		tb.cap = math.Max(1, math.Ceil(tb.Rate))
+	}
+	tb.buckets = newLRU(maxKeys(tb.MaxKeys), tb.idle)
+}
+
+// Allow implements Limiter for the TokenBucket. It returns an error if
+// the configuration is invalid.
+func (tb *TokenBucket) Allow(key string, n int64) (Result, error) {
+	tb.once.Do(tb.init)
+	if tb.err != nil {
+		return Result{}, tb.err
+	}
+
+	now := timeNow()
+	res := Result{Limit: int64(tb.cap), Window: secondsDuration(tb.cap / tb.Rate)}
+	n = capCost(n, res.Limit)
+	tb.buckets.update(key, now, func(v interface{}) (interface{}, time.Time) {
+		b := bucket{tokens: tb.cap}
+		if v != nil {
+			b = v.(bucket)
+			b.tokens = math.Min(tb.cap, b.tokens+now.Sub(b.last).Seconds()*tb.Rate)
+		}
+		b.last = now
+
+		if b.tokens >= float64(n) {
+			b.tokens -= float64(n)
+			res.Allowed = true
+		} else {
+			res.RetryAfter = secondsDuration((float64(n) - b.tokens) / tb.Rate)
+		}
+		res.Remaining = int64(b.tokens)
+		res.Reset = secondsDuration((tb.cap - b.tokens) / tb.Rate)
+
+		// once full, the bucket is the same as a new one
+		return b, now.Add(res.Reset)
+	})
+	return res, nil
+}
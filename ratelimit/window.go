@@ -0,0 +1,215 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FixedWindow is a Limiter that allows Limit requests per Window, e.g.
+// 1000 requests per hour. The windows are aligned on the zero time, so an
+// hourly window starts at the beginning of each hour (UTC). It allows
+// bursts of up to 2*Limit requests around the start of a window.
+type FixedWindow struct {
+	// Limit is the number of requests allowed per Window.
+	Limit int64
+
+	// Window is the duration of a window. It must be > 0.
+	Window time.Duration
+
+	// Store is the store of the state of the keys. Defaults to a
+	// MemoryStore with MaxKeys.
+	Store Store
+
+	// MaxKeys is the maximum number of keys of the default Store. Defaults
+	// to DefaultMaxKeys.
+	MaxKeys int
+
+	once sync.Once
+	err  error
+	st   Store
+}
+
+// Validate returns an error if the configuration is invalid.
+func (fw *FixedWindow) Validate() error {
+	if fw.Window <= 0 {
+		return errors.New("ratelimit: FixedWindow window must be > 0")
+	}
+	return nil
+}
+
+func (fw *FixedWindow) init() {
+	if fw.err = fw.Validate(); fw.err != nil {
+		return
+	}
+	fw.st = fw.Store
+	if fw.st == nil {
+		fw.st = &MemoryStore{MaxKeys: fw.MaxKeys}
+	}
+}
+
+// Allow implements Limiter for the FixedWindow. It returns an error if
+// the configuration is invalid.
+func (fw *FixedWindow) Allow(key string, n int64) (Result, error) {
+	fw.once.Do(fw.init)
+	if fw.err != nil {
+		return Result{}, fw.err
+	}
+
+	n = capCost(n, fw.Limit)
+	now := timeNow()
+	idx, end := window(now, fw.Window)
+	ttl := end.Sub(now)
+	k := windowKey(key, idx)
+	count, err := fw.st.Incr(k, n, ttl)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Allowed: true, Limit: fw.Limit, Window: fw.Window, Reset: ttl}
+	if count > fw.Limit {
+		if _, err := fw.st.Incr(k, -n, ttl); err != nil {
+			return Result{}, err
+		}
+		count -= n
+		res.Allowed = false
+		res.RetryAfter = ttl
+	}
+	res.Remaining = remaining(fw.Limit, float64(count))
+	return res, nil
+}
+
+// SlidingWindow is a Limiter that allows Limit requests per sliding
+// Window, e.g. 1000 requests in the last hour. It uses the sliding window
+// counter algorithm, which estimates the number of requests in the
+// sliding window from the counts of the current and previous fixed
+// windows, weighting the previous one by its overlap with the sliding
+// window. Unlike FixedWindow, it does not allow bursts at the start of
+// the windows.
+type SlidingWindow struct {
+	// Limit is the number of requests allowed per Window.
+	Limit int64
+
+	// Window is the duration of the sliding window. It must be > 0.
+	Window time.Duration
+
+	// Store is the store of the state of the keys. Defaults to a
+	// MemoryStore with MaxKeys.
+	Store Store
+
+	// MaxKeys is the maximum number of keys of the default Store. Defaults
+	// to DefaultMaxKeys.
+	MaxKeys int
+
+	once sync.Once
+	err  error
+	st   Store
+}
+
+// Validate returns an error if the configuration is invalid.
+func (sw *SlidingWindow) Validate() error {
+	if sw.Window <= 0 {
+		return errors.New("ratelimit: SlidingWindow window must be > 0")
+	}
+	return nil
+}
+
+func (sw *SlidingWindow) init() {
+	if sw.err = sw.Validate(); sw.err != nil {
+		return
+	}
+	sw.st = sw.Store
+	if sw.st == nil {
+		sw.st = &MemoryStore{MaxKeys: sw.MaxKeys}
+	}
+}
+
+// Allow implements Limiter for the SlidingWindow. It returns an error if
+// the configuration is invalid.
+func (sw *SlidingWindow) Allow(key string, n int64) (Result, error) {
+	sw.once.Do(sw.init)
+	if sw.err != nil {
+		return Result{}, sw.err
+	}
+
+	n = capCost(n, sw.Limit)
+	now := timeNow()
+	idx, end := window(now, sw.Window)
+	elapsed := sw.Window - end.Sub(now)
+
+	prev, err := sw.st.Get(windowKey(key, idx-1))
+	if err != nil {
+		return Result{}, err
+	}
+	// the current window is the previous one during the next window
+	k := windowKey(key, idx)
+	ttl := end.Sub(now) + sw.Window
+	cur, err := sw.st.Incr(k, n, ttl)
+	if err != nil {
+		return Result{}, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(sw.Window)
+	res := Result{Allowed: true, Limit: sw.Limit, Window: sw.Window}
+	if float64(prev)*weight+float64(cur) > float64(sw.Limit) {
+		if _, err := sw.st.Incr(k, -n, ttl); err != nil {
+			return Result{}, err
+		}
+		cur -= n
+		res.Allowed = false
+		res.RetryAfter = sw.retryAfter(prev, cur, n, elapsed, end.Sub(now))
+	}
+	res.Remaining = remaining(sw.Limit, float64(prev)*weight+float64(cur))
+
+	switch {
+	case cur > 0:
+		res.Reset = end.Sub(now) + sw.Window
+	case prev > 0:
+		res.Reset = end.Sub(now)
+	}
+	return res, nil
+}
+
+// retryAfter returns the time until a request of n units would be allowed,
+// given the counts of the previous and current windows.
+func (sw *SlidingWindow) retryAfter(prev, cur, n int64, elapsed, left time.Duration) time.Duration {
+	w := float64(sw.Window)
+
+	// in the current window, once the weight of the previous one is low enough
+	if free := sw.Limit - cur - n; free >= 0 && prev > 0 {
+		weight := float64(free) / float64(prev)
+		return time.Duration((1-weight)*w) - elapsed
+	}
+	// in the next window, where the current window is the previous one
+	if free := sw.Limit - n; free >= 0 && cur > 0 {
+		weight := float64(free) / float64(cur)
+		return left + time.Duration((1-weight)*w)
+	}
+	return left + sw.Window
+}
+
+// window returns the index of the window of duration d that contains t,
+// and the end time of that window.
+func window(t time.Time, d time.Duration) (int64, time.Time) {
+	idx := t.UnixNano() / int64(d)
+	return idx, time.Unix(0, (idx+1)*int64(d))
+}
+
+func windowKey(key string, idx int64) string {
+	return key + ":" + strconv.FormatInt(idx, 10)
+}
+
+// remaining returns the number of requests remaining under limit, given
+// the (estimated) count.
+func remaining(limit int64, count float64) int64 {
+	if rem := limit - int64(math.Ceil(count)); rem > 0 {
+		return rem
+	}
+	return 0
+}
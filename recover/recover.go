@@ -7,6 +7,9 @@
 package recover
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
 	"runtime"
 
@@ -22,28 +25,129 @@ type Recover struct {
 	// StackTrace indicates if the stack trace should be logged
 	// in addition to the panic.
 	StackTrace bool
+
+	// PanicHandler writes the response of a request that panicked
+	// with the value v, before the response was written. The stack
+	// is the stack trace of the panic. If it is nil, a 500 status
+	// code is returned.
+	PanicHandler func(w http.ResponseWriter, r *http.Request, v interface{}, stack []byte)
 }
 
-// Wrap returns a handler that recovers from panics by returning a
-// 500 status code and optionally logging the panic and stack trace.
+// Wrap returns a handler that recovers from panics by calling the
+// PanicHandler and optionally logging the panic and stack trace.
+//
+// If the response was already written (at least partially) when the
+// handler panicked, it cannot be replaced, so the PanicHandler is not
+// called and the connection is aborted by panicking with
+// http.ErrAbortHandler. A panic with http.ErrAbortHandler is not
+// recovered, so that the connection is aborted as expected.
 func (rv *Recover) Wrap(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &committedWriter{ResponseWriter: w}
+		var ww http.ResponseWriter = cw
+		if _, ok := w.(statusSizer); ok {
+			ww = augmentedWriter{cw}
+		}
 		defer func() {
-			if e := recover(); e != nil {
-				if rv.Logger != nil {
-					args := []interface{}{"panic", e}
-					if rv.StackTrace {
-						b := make([]byte, 4096)
-						if n := runtime.Stack(b, false); n > 0 {
-							args = append(args, "stack", string(b[:n]))
-						}
-					}
-					rv.Logger.Log(args...)
+			e := recover()
+			if e == nil {
+				return
+			}
+			if e == http.ErrAbortHandler {
+				panic(e)
+			}
+
+			var stack []byte
+			if rv.StackTrace || rv.PanicHandler != nil {
+				b := make([]byte, 4096)
+				stack = b[:runtime.Stack(b, false)]
+			}
+			if rv.Logger != nil {
+				args := []interface{}{"panic", e}
+				if rv.StackTrace && len(stack) > 0 {
+					args = append(args, "stack", string(stack))
 				}
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				rv.Logger.Log(args...)
+			}
+
+			if cw.committed {
+				panic(http.ErrAbortHandler)
+			}
+			if rv.PanicHandler != nil {
+				rv.PanicHandler(w, r, e, stack)
+				return
 			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		}()
 
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(ww, r)
 	})
 }
+
+// committedWriter is a response writer that records if the response's
+// header was written.
+type committedWriter struct {
+	http.ResponseWriter
+	committed bool
+}
+
+func (w *committedWriter) WriteHeader(code int) {
+	w.committed = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *committedWriter) Write(b []byte) (int, error) {
+	w.committed = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *committedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack is not supported")
+	}
+	w.committed = true
+	return hj.Hijack()
+}
+
+func (w *committedWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w *committedWriter) Flush() {
+	f, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		w.committed = true
+		f.Flush()
+	}
+}
+
+func (w *committedWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// statusSizer is implemented by the response writer of the augmentedrw
+// package.
+type statusSizer interface {
+	Status() int
+	Size() int
+}
+
+// augmentedWriter is a committedWriter that forwards the Status and Size
+// methods of the response writer, so that they are still available to the
+// handlers that follow.
+type augmentedWriter struct {
+	*committedWriter
+}
+
+func (w augmentedWriter) Status() int {
+	return w.ResponseWriter.(statusSizer).Status()
+}
+
+func (w augmentedWriter) Size() int {
+	return w.ResponseWriter.(statusSizer).Size()
+}
@@ -5,15 +5,23 @@
 package recover
 
 import (
+	"bytes"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/PuerkitoBio/httpmw"
+	"github.com/PuerkitoBio/httpmw/augmentedrw"
+	"github.com/PuerkitoBio/httpmw/logrequest"
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 )
 
+type logFunc func(args ...interface{}) error
+
+func (fn logFunc) Log(args ...interface{}) error { return fn(args...) }
+
 func TestRecover(t *testing.T) {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		panic(io.EOF)
@@ -27,3 +35,95 @@ func TestRecover(t *testing.T) {
 	h.ServeHTTP(w, r)
 	assert.Equal(t, 500, w.Code, "status")
 }
+
+func TestRecoverPanicHandler(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(io.EOF)
+	})
+
+	var logged []interface{}
+	rec := &Recover{
+		Logger: logFunc(func(args ...interface{}) error {
+			logged = args
+			return nil
+		}),
+		PanicHandler: func(w http.ResponseWriter, r *http.Request, v interface{}, stack []byte) {
+			assert.Equal(t, io.EOF, v, "value")
+			assert.Contains(t, string(stack), "TestRecoverPanicHandler", "stack")
+			w.WriteHeader(503)
+			io.WriteString(w, "unavailable")
+		},
+	}
+	h := httpmw.Wrap(fn, rec)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 503, w.Code, "status")
+	assert.Equal(t, "unavailable", w.Body.String(), "body")
+	assert.Equal(t, []interface{}{"panic", io.EOF}, logged, "logged")
+}
+
+func TestRecoverAbort(t *testing.T) {
+	cases := []struct {
+		fn     func(w http.ResponseWriter)
+		logged bool
+	}{
+		{func(w http.ResponseWriter) { panic(http.ErrAbortHandler) }, false},
+		{func(w http.ResponseWriter) { w.WriteHeader(201); panic(io.EOF) }, true},
+		{func(w http.ResponseWriter) { io.WriteString(w, "a"); panic(io.EOF) }, true},
+		{func(w http.ResponseWriter) { w.(http.Flusher).Flush(); panic(io.EOF) }, true},
+	}
+	for i, c := range cases {
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.fn(w)
+		})
+
+		var logged bool
+		var called bool
+		rec := &Recover{
+			Logger: logFunc(func(args ...interface{}) error {
+				logged = true
+				return nil
+			}),
+			PanicHandler: func(w http.ResponseWriter, r *http.Request, v interface{}, stack []byte) {
+				called = true
+			},
+		}
+		h := httpmw.Wrap(fn, rec)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("", "/", nil)
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() { h.ServeHTTP(w, r) }, "%d: abort", i)
+		assert.Equal(t, c.logged, logged, "%d: logged", i)
+		assert.False(t, called, "%d: panic handler called", i)
+	}
+}
+
+func TestRecoverWriterInterfaces(t *testing.T) {
+	var buf bytes.Buffer
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Pusher)
+		assert.True(t, ok, "pusher")
+		w.WriteHeader(201)
+		io.WriteString(w, "hello")
+	})
+	lr := &logrequest.LogRequest{Logger: log.NewLogfmtLogger(&buf), Fields: []string{"status", "body_bytes_sent"}}
+	h := httpmw.Wrap(fn, httpmw.WrapperFunc(augmentedrw.Wrap), &Recover{}, lr)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code, "status")
+	assert.Equal(t, "status=201 body_bytes_sent=5\n", buf.String(), "logged")
+
+	// without augmentedrw, the Status and Size methods are not added
+	fn = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(interface {
+			Status() int
+			Size() int
+		})
+		assert.False(t, ok, "status and size")
+	})
+	httpmw.Wrap(fn, &Recover{}).ServeHTTP(httptest.NewRecorder(), r)
+}
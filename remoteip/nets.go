@@ -0,0 +1,60 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package remoteip
+
+import (
+	"net"
+	"strings"
+)
+
+// Nets is a list of IP networks, e.g. to match the address of trusted
+// proxies or of allowed clients.
+type Nets []*net.IPNet
+
+// ParseNets parses the list of IP addresses or CIDR ranges (e.g.
+// "10.0.0.0/8"). An IP address is a network with a single address.
+func ParseNets(list []string) (Nets, error) {
+	nets := make(Nets, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: s}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Contains returns true if the address addr is in one of the networks.
+// The address may have a port, as the request's RemoteAddr field.
+func (nets Nets) Contains(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package remoteip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNets(t *testing.T) {
+	nets, err := ParseNets([]string{"10.0.0.0/8", "1.2.3.4", "::1", "fd00::/8"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.1.2.3:1000", true},
+		{"11.1.2.3", false},
+		{"1.2.3.4:80", true},
+		{"1.2.3.5", false},
+		{"[::1]:80", true},
+		{"::2", false},
+		{"fd12::1", true},
+		{"", false},
+		{"invalid", false},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.want, nets.Contains(c.addr), "%d: %s", i, c.addr)
+	}
+
+	for _, s := range []string{"x", "1.2.3.4/99", "1.2.3"} {
+		_, err := ParseNets([]string{s})
+		assert.Error(t, err, s)
+	}
+}
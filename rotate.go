@@ -0,0 +1,356 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpmw
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backupTimeFormat is the format of the timestamp in the name of the
+// rotated files.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// timeNow is the function that returns the current time, replaced in tests.
+var timeNow = time.Now
+
+// RotatingFile is an io.Writer that writes to a file and rotates it based
+// on its size and/or on time, e.g. to write the access logs of the
+// logrequest middleware, or the output of any Logger. It is safe for
+// concurrent use. The file is opened (or created) on the first Write, in
+// append mode.
+//
+// A rotated file is renamed with the time of the rotation (in UTC) added
+// before its extension, e.g. access-2016-01-02T15-04-05.000.log for
+// access.log, and a new file is created. Compression and removal of old
+// backups happen in the background.
+type RotatingFile struct {
+	// Filename is the path of the file to write to. It is required.
+	Filename string
+
+	// MaxSize is the maximum size of the file in bytes. A Write that would
+	// make the file bigger than MaxSize first rotates it. Disabled if <= 0.
+	MaxSize int64
+
+	// RotateEvery is the period of the time-based rotation, e.g. 24 hours
+	// to rotate the file daily. Periods are aligned on the zero time, so
+	// that a daily rotation happens at midnight UTC. Disabled if <= 0.
+	RotateEvery time.Duration
+
+	// MaxBackups is the maximum number of rotated files to keep. Disabled
+	// if <= 0.
+	MaxBackups int
+
+	// MaxAge is the maximum age of the rotated files to keep, based on
+	// the time in their name. Disabled if <= 0.
+	MaxAge time.Duration
+
+	// Compress compresses the rotated files with gzip.
+	Compress bool
+
+	// Logger is used to log the errors that occur in the background, when
+	// compressing or removing rotated files. If nil, they are ignored.
+	Logger Logger
+
+	mu         sync.Mutex
+	f          *os.File
+	size       int64
+	nextRotate time.Time
+	sigc       chan os.Signal
+	done       chan struct{}
+
+	bgMu sync.Mutex // serializes the background work
+	wg   sync.WaitGroup
+}
+
+// Write implements io.Writer for the RotatingFile. It rotates the file
+// first if required.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	sizeExceeded := rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize
+	timeExceeded := rf.RotateEvery > 0 && !timeNow().Before(rf.nextRotate)
+	if sizeExceeded || timeExceeded {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the file, renames it as a rotated file and creates a new
+// one.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotate()
+}
+
+// Reopen closes and reopens the file, without rotating it. It is useful
+// when the file is rotated by an external tool.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if err := rf.close(); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// ReopenOnSignal calls Reopen each time one of the signals sigs is
+// received, until Close is called. Defaults to SIGHUP if no signal is
+// provided.
+func (rf *RotatingFile) ReopenOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.sigc != nil {
+		signal.Notify(rf.sigc, sigs...)
+		return
+	}
+
+	rf.sigc = make(chan os.Signal, 1)
+	rf.done = make(chan struct{})
+	signal.Notify(rf.sigc, sigs...)
+	go func(sigc chan os.Signal, done chan struct{}) {
+		for {
+			select {
+			case <-sigc:
+				if err := rf.Reopen(); err != nil {
+					rf.logError("reopen", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}(rf.sigc, rf.done)
+}
+
+// Close stops listening for the signals, closes the file and waits for
+// the background work to complete. A subsequent Write reopens the file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	if rf.sigc != nil {
+		signal.Stop(rf.sigc)
+		close(rf.done)
+		rf.sigc, rf.done = nil, nil
+	}
+	err := rf.close()
+	rf.mu.Unlock()
+
+	rf.wg.Wait()
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	if rf.Filename == "" {
+		return errors.New("httpmw: RotatingFile without Filename")
+	}
+	if err := os.MkdirAll(filepath.Dir(rf.Filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(rf.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = fi.Size()
+	if rf.RotateEvery > 0 {
+		// an existing file from a previous period is rotated on the next write
+		start := timeNow()
+		if rf.size > 0 && fi.ModTime().Before(start) {
+			start = fi.ModTime()
+		}
+		rf.nextRotate = start.Truncate(rf.RotateEvery).Add(rf.RotateEvery)
+	}
+	return nil
+}
+
+func (rf *RotatingFile) close() error {
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.close(); err != nil {
+		return err
+	}
+
+	var backup string
+	if _, err := os.Stat(rf.Filename); err == nil {
+		backup = rf.backupName(timeNow())
+		if err := os.Rename(rf.Filename, backup); err != nil {
+			return err
+		}
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	rf.wg.Add(1)
+	go rf.background(backup)
+	return nil
+}
+
+// backupName returns a free name for a rotated file at time t.
+func (rf *RotatingFile) backupName(t time.Time) string {
+	prefix, ext := rf.backupParts()
+	for {
+		name := prefix + t.UTC().Format(backupTimeFormat) + ext
+		_, err1 := os.Stat(name)
+		_, err2 := os.Stat(name + ".gz")
+		if os.IsNotExist(err1) && os.IsNotExist(err2) {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// backupParts returns the prefix and extension of the rotated files.
+func (rf *RotatingFile) backupParts() (prefix, ext string) {
+	ext = filepath.Ext(rf.Filename)
+	return strings.TrimSuffix(rf.Filename, ext) + "-", ext
+}
+
+// background compresses the rotated file backup, if any, and removes the
+// old rotated files.
+func (rf *RotatingFile) background(backup string) {
+	defer rf.wg.Done()
+	rf.bgMu.Lock()
+	defer rf.bgMu.Unlock()
+
+	if backup != "" && rf.Compress {
+		if err := compressFile(backup); err != nil {
+			rf.logError("compress", err)
+		}
+	}
+	if err := rf.removeOld(); err != nil {
+		rf.logError("remove", err)
+	}
+}
+
+// backupFile is a rotated file and its rotation time.
+type backupFile struct {
+	name string
+	t    time.Time
+}
+
+// backups returns the rotated files, newest first.
+func (rf *RotatingFile) backups() ([]backupFile, error) {
+	prefix, ext := rf.backupParts()
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []backupFile
+	for _, name := range matches {
+		ts := strings.TrimPrefix(name, prefix)
+		ts = strings.TrimSuffix(ts, ".gz")
+		if !strings.HasSuffix(ts, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(ts, ext))
+		if err != nil {
+			continue
+		}
+		files = append(files, backupFile{name: name, t: t})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].t.After(files[j].t) })
+	return files, nil
+}
+
+func (rf *RotatingFile) removeOld() error {
+	if rf.MaxBackups <= 0 && rf.MaxAge <= 0 {
+		return nil
+	}
+	files, err := rf.backups()
+	if err != nil {
+		return err
+	}
+
+	cutoff := timeNow().Add(-rf.MaxAge)
+	var firstErr error
+	for i, bf := range files {
+		if (rf.MaxBackups > 0 && i >= rf.MaxBackups) || (rf.MaxAge > 0 && bf.t.Before(cutoff)) {
+			if err := os.Remove(bf.name); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (rf *RotatingFile) logError(op string, err error) {
+	if rf.Logger != nil {
+		rf.Logger.Log("level", "error", "op", op, "file", rf.Filename, "error", err)
+	}
+}
+
+// compressFile compresses name to name.gz and removes name.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, name+".gz"); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
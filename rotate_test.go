@@ -0,0 +1,160 @@
+package httpmw
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setClock replaces timeNow with a clock that starts at t and returns a
+// function to advance it.
+func setClock(t *testing.T, start time.Time) func(time.Duration) {
+	var mu sync.Mutex
+	now := start
+	timeNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+	return func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+}
+
+func dirFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	advance := setClock(t, time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	rf := &RotatingFile{Filename: filepath.Join(dir, "access.log"), MaxSize: 10, MaxBackups: 2}
+	for i := 0; i < 4; i++ {
+		_, err := fmt.Fprintf(rf, "line %d\n", i)
+		require.NoError(t, err, "%d: write", i)
+		advance(time.Second)
+	}
+	require.NoError(t, rf.Close(), "close")
+
+	assert.Equal(t, []string{
+		"access-2016-01-02T03-04-07.000.log",
+		"access-2016-01-02T03-04-08.000.log",
+		"access.log",
+	}, dirFiles(t, dir), "files")
+	assert.Equal(t, "line 1\n", readFile(t, filepath.Join(dir, "access-2016-01-02T03-04-07.000.log")), "backup")
+	assert.Equal(t, "line 3\n", readFile(t, filepath.Join(dir, "access.log")), "current")
+}
+
+func TestRotatingFileTime(t *testing.T) {
+	dir := t.TempDir()
+	advance := setClock(t, time.Date(2016, 1, 2, 23, 0, 0, 0, time.UTC))
+
+	rf := &RotatingFile{Filename: filepath.Join(dir, "access.log"), RotateEvery: 24 * time.Hour, MaxAge: 12 * time.Hour, Compress: true}
+	for i := 0; i < 4; i++ {
+		_, err := fmt.Fprintf(rf, "line %d\n", i)
+		require.NoError(t, err, "%d: write", i)
+		advance(12 * time.Hour)
+	}
+	require.NoError(t, rf.Close(), "close")
+
+	// written on the 2nd at 23:00, 3rd at 11:00 and 23:00 and 4th at 11:00,
+	// rotated on the 3rd and 4th at 11:00, and the first backup is older
+	// than MaxAge when the second one is created.
+	assert.Equal(t, []string{
+		"access-2016-01-04T11-00-00.000.log.gz",
+		"access.log",
+	}, dirFiles(t, dir), "files")
+
+	f, err := os.Open(filepath.Join(dir, "access-2016-01-04T11-00-00.000.log.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\n", string(b), "compressed backup")
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "access.log")
+	rf := &RotatingFile{Filename: name}
+	defer rf.Close()
+	rf.ReopenOnSignal()
+
+	_, err := rf.Write([]byte("a\n"))
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(name, name+".1"), "external rotation")
+
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("cannot send SIGHUP: %v", err)
+	}
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(name)
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "reopened")
+
+	_, err = rf.Write([]byte("b\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\n", readFile(t, name+".1"), "rotated")
+	assert.Equal(t, "b\n", readFile(t, name), "reopened")
+}
+
+func TestRotatingFileConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	rf := &RotatingFile{Filename: filepath.Join(dir, "access.log"), MaxSize: 100}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rf.Write([]byte("0123456789\n"))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, rf.Close(), "close")
+
+	var total int
+	for _, name := range dirFiles(t, dir) {
+		total += len(readFile(t, filepath.Join(dir, name)))
+	}
+	assert.Equal(t, 10*100*11, total, "all bytes written")
+}
+
+func TestRotatingFileNoFilename(t *testing.T) {
+	var rf RotatingFile
+	_, err := rf.Write([]byte("a"))
+	assert.Error(t, err, "no filename")
+}
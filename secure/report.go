@@ -0,0 +1,158 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secure
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/httpmw"
+)
+
+// DefaultMaxReportBytes is the default maximum size of a CSP violation
+// report request body.
+const DefaultMaxReportBytes = 64 << 10
+
+// ReportHandler is a handler that receives the Content-Security-Policy
+// violation reports sent by browsers to the report-uri or report-to
+// endpoint, and logs them.
+type ReportHandler struct {
+	// Logger is the logger used to log the violation reports. If it is
+	// nil, the reports are received but discarded.
+	Logger httpmw.Logger
+
+	// MaxBytes is the maximum size of the request body. Defaults to
+	// DefaultMaxReportBytes.
+	MaxBytes int64
+}
+
+// cspReport holds the fields of a violation report. The json field names
+// are those of the report-uri format, the Reporting API field names are
+// mapped in reportingBody.
+type cspReport struct {
+	DocumentURI        string      `json:"document-uri"`
+	Referrer           string      `json:"referrer"`
+	BlockedURI         string      `json:"blocked-uri"`
+	ViolatedDirective  string      `json:"violated-directive"`
+	EffectiveDirective string      `json:"effective-directive"`
+	OriginalPolicy     string      `json:"original-policy"`
+	Disposition        string      `json:"disposition"`
+	SourceFile         string      `json:"source-file"`
+	LineNumber         json.Number `json:"line-number"`
+	ColumnNumber       json.Number `json:"column-number"`
+	StatusCode         json.Number `json:"status-code"`
+	ScriptSample       string      `json:"script-sample"`
+}
+
+// reportingBody is the body of a csp-violation report sent via the
+// Reporting API (report-to directive).
+type reportingBody struct {
+	DocumentURL        string      `json:"documentURL"`
+	Referrer           string      `json:"referrer"`
+	BlockedURL         string      `json:"blockedURL"`
+	EffectiveDirective string      `json:"effectiveDirective"`
+	OriginalPolicy     string      `json:"originalPolicy"`
+	Disposition        string      `json:"disposition"`
+	SourceFile         string      `json:"sourceFile"`
+	LineNumber         json.Number `json:"lineNumber"`
+	ColumnNumber       json.Number `json:"columnNumber"`
+	StatusCode         json.Number `json:"statusCode"`
+	Sample             string      `json:"sample"`
+}
+
+func (b reportingBody) report() cspReport {
+	return cspReport{
+		DocumentURI:        b.DocumentURL,
+		Referrer:           b.Referrer,
+		BlockedURI:         b.BlockedURL,
+		ViolatedDirective:  b.EffectiveDirective,
+		EffectiveDirective: b.EffectiveDirective,
+		OriginalPolicy:     b.OriginalPolicy,
+		Disposition:        b.Disposition,
+		SourceFile:         b.SourceFile,
+		LineNumber:         b.LineNumber,
+		ColumnNumber:       b.ColumnNumber,
+		StatusCode:         b.StatusCode,
+		ScriptSample:       b.Sample,
+	}
+}
+
+// ServeHTTP handles a POST request containing violation reports, either
+// in the report-uri format (application/csp-report) or in the Reporting
+// API format (application/reports+json). Each report is logged with its
+// non-empty fields and the response is a status code 204. It returns a
+// status code 405 if the method is not POST, and 400 if the body cannot
+// be decoded.
+func (rh *ReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	max := rh.MaxBytes
+	if max <= 0 {
+		max = DefaultMaxReportBytes
+	}
+	body := http.MaxBytesReader(w, r.Body, max)
+	defer body.Close()
+
+	var reports []cspReport
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var list []struct {
+			Type string        `json:"type"`
+			Body reportingBody `json:"body"`
+		}
+		if err = json.NewDecoder(body).Decode(&list); err == nil {
+			for _, rep := range list {
+				if rep.Type == "csp-violation" {
+					reports = append(reports, rep.Body.report())
+				}
+			}
+		}
+	} else {
+		var rep struct {
+			Report cspReport `json:"csp-report"`
+		}
+		if err = json.NewDecoder(body).Decode(&rep); err == nil {
+			reports = append(reports, rep.Report)
+		}
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if rh.Logger != nil {
+		for _, rep := range reports {
+			rh.Logger.Log(rep.logArgs()...)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logArgs returns the key-value pairs to log for the report.
+func (rep cspReport) logArgs() []interface{} {
+	args := []interface{}{"csp_violation", rep.ViolatedDirective}
+	add := func(k, v string) {
+		if v != "" {
+			args = append(args, k, v)
+		}
+	}
+	add("document_uri", rep.DocumentURI)
+	add("referrer", rep.Referrer)
+	add("blocked_uri", rep.BlockedURI)
+	add("effective_directive", rep.EffectiveDirective)
+	add("disposition", rep.Disposition)
+	add("source_file", rep.SourceFile)
+	add("line_number", rep.LineNumber.String())
+	add("column_number", rep.ColumnNumber.String())
+	add("status_code", rep.StatusCode.String())
+	add("script_sample", rep.ScriptSample)
+	add("original_policy", rep.OriginalPolicy)
+	return args
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secure
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportHandler(t *testing.T) {
+	cases := []struct {
+		method string
+		ctype  string
+		body   string
+		max    int64
+		code   int
+		out    string
+	}{
+		{"GET", "", "", 0, 405, ""},
+		{"POST", "application/csp-report", "{", 0, 400, ""},
+		{"POST", "application/csp-report", `{"csp-report": {
+			"document-uri": "https://example.com/a",
+			"violated-directive": "script-src-elem",
+			"effective-directive": "script-src-elem",
+			"blocked-uri": "inline",
+			"line-number": 12,
+			"disposition": "enforce"
+		}}`, 0, 204, `csp_violation="script-src-elem" document_uri="https://example.com/a" blocked_uri="inline" effective_directive="script-src-elem" disposition="enforce" line_number="12"` + "\n"},
+		{"POST", "application/reports+json", `[{
+			"type": "csp-violation",
+			"body": {
+				"documentURL": "https://example.com/b",
+				"effectiveDirective": "img-src",
+				"blockedURL": "https://evil.com/x.png",
+				"disposition": "report",
+				"statusCode": 200
+			}
+		}, {
+			"type": "deprecation",
+			"body": {}
+		}]`, 0, 204, `csp_violation="img-src" document_uri="https://example.com/b" blocked_uri="https://evil.com/x.png" effective_directive="img-src" disposition="report" status_code="200"` + "\n"},
+		{"POST", "application/csp-report", `{"csp-report": {"document-uri": "` + strings.Repeat("x", 100) + `"}}`, 100, 400, ""},
+	}
+
+	var buf bytes.Buffer
+	l := httpmw.PrintfLogger(log.New(&buf, "", 0).Printf)
+	for i, c := range cases {
+		buf.Reset()
+		rh := &ReportHandler{Logger: l, MaxBytes: c.max}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(c.method, "/csp", strings.NewReader(c.body))
+		if c.ctype != "" {
+			r.Header.Set("Content-Type", c.ctype)
+		}
+
+		rh.ServeHTTP(w, r)
+		assert.Equal(t, c.code, w.Code, "%d: status", i)
+		assert.Equal(t, c.out, buf.String(), "%d: output", i)
+	}
+}
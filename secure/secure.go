@@ -0,0 +1,160 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package secure implements a middleware that sets security-related
+// response headers with sensible defaults, including a
+// Content-Security-Policy with a per-request nonce, and a handler that
+// receives and logs Content-Security-Policy violation reports.
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// NoncePlaceholder is the placeholder replaced by the per-request nonce
+// in the ContentSecurityPolicy, e.g. "script-src 'nonce-{nonce}'".
+const NoncePlaceholder = "{nonce}"
+
+// Omit is the value to assign to a header field of Secure to prevent
+// the middleware from setting this header.
+const Omit = "-"
+
+// Default values of the headers set by the middleware.
+const (
+	DefaultContentTypeOptions        = "nosniff"
+	DefaultReferrerPolicy            = "strict-origin-when-cross-origin"
+	DefaultCrossOriginOpenerPolicy   = "same-origin"
+	DefaultCrossOriginResourcePolicy = "same-origin"
+	DefaultFrameOptions              = "DENY"
+)
+
+// Secure holds the configuration for the security headers middleware.
+// A header field set to Omit is not set by the middleware. Fields that
+// have a default value use it if they are empty, other fields are not
+// set if they are empty.
+type Secure struct {
+	// ContentSecurityPolicy is the value of the Content-Security-Policy
+	// header. Each occurrence of NoncePlaceholder is replaced with a
+	// random nonce generated for each request, which is available to
+	// the handlers via the Nonce function.
+	ContentSecurityPolicy string
+
+	// CSPReportOnly sets the ContentSecurityPolicy in the
+	// Content-Security-Policy-Report-Only header instead, so that
+	// violations are reported but not enforced.
+	CSPReportOnly bool
+
+	// ContentTypeOptions is the value of the X-Content-Type-Options
+	// header. Defaults to DefaultContentTypeOptions.
+	ContentTypeOptions string
+
+	// ReferrerPolicy is the value of the Referrer-Policy header.
+	// Defaults to DefaultReferrerPolicy.
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the value of the Permissions-Policy header,
+	// e.g. "camera=(), microphone=()".
+	PermissionsPolicy string
+
+	// CrossOriginOpenerPolicy is the value of the Cross-Origin-Opener-Policy
+	// header. Defaults to DefaultCrossOriginOpenerPolicy.
+	CrossOriginOpenerPolicy string
+
+	// CrossOriginEmbedderPolicy is the value of the
+	// Cross-Origin-Embedder-Policy header, e.g. "require-corp".
+	CrossOriginEmbedderPolicy string
+
+	// CrossOriginResourcePolicy is the value of the
+	// Cross-Origin-Resource-Policy header. Defaults to
+	// DefaultCrossOriginResourcePolicy.
+	CrossOriginResourcePolicy string
+
+	// FrameOptions is the value of the X-Frame-Options header. Defaults
+	// to DefaultFrameOptions.
+	FrameOptions string
+}
+
+type contextKey int
+
+const nonceKey contextKey = 0
+
+// Nonce returns the Content-Security-Policy nonce generated for the
+// request, or an empty string if there is none. It can be used e.g. to
+// set the nonce attribute of script elements in templates.
+func Nonce(r *http.Request) string {
+	v, _ := r.Context().Value(nonceKey).(string)
+	return v
+}
+
+// for tests
+var testForceRandErr bool
+
+// Wrap returns a handler that sets the configured security headers before
+// calling the handler h. If the ContentSecurityPolicy requires a nonce
+// and a random nonce cannot be generated, it returns a status code 500.
+func (s *Secure) Wrap(h http.Handler) http.Handler {
+	var headers [][2]string
+	add := func(name, val, def string) {
+		if val == "" {
+			val = def
+		}
+		if val != "" && val != Omit {
+			headers = append(headers, [2]string{name, val})
+		}
+	}
+	add("X-Content-Type-Options", s.ContentTypeOptions, DefaultContentTypeOptions)
+	add("Referrer-Policy", s.ReferrerPolicy, DefaultReferrerPolicy)
+	add("Permissions-Policy", s.PermissionsPolicy, "")
+	add("Cross-Origin-Opener-Policy", s.CrossOriginOpenerPolicy, DefaultCrossOriginOpenerPolicy)
+	add("Cross-Origin-Embedder-Policy", s.CrossOriginEmbedderPolicy, "")
+	add("Cross-Origin-Resource-Policy", s.CrossOriginResourcePolicy, DefaultCrossOriginResourcePolicy)
+	add("X-Frame-Options", s.FrameOptions, DefaultFrameOptions)
+
+	csp := s.ContentSecurityPolicy
+	if csp == Omit {
+		csp = ""
+	}
+	cspHeader := "Content-Security-Policy"
+	if s.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	useNonce := strings.Contains(csp, NoncePlaceholder)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hd := w.Header()
+		for _, kv := range headers {
+			hd.Set(kv[0], kv[1])
+		}
+
+		if useNonce {
+			nonce, err := newNonce()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			hd.Set(cspHeader, strings.Replace(csp, NoncePlaceholder, nonce, -1))
+			r = r.WithContext(context.WithValue(r.Context(), nonceKey, nonce))
+		} else if csp != "" {
+			hd.Set(cspHeader, csp)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newNonce returns a new random nonce.
+func newNonce() (string, error) {
+	if testForceRandErr {
+		return "", errors.New("forced random error")
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Martin Angers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PuerkitoBio/httpmw"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureDefaults(t *testing.T) {
+	var s Secure
+	h := httpmw.Wrap(httpmw.StatusHandler(200), &s)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code, "status")
+	assert.Equal(t, http.Header{
+		"X-Content-Type-Options":       {"nosniff"},
+		"Referrer-Policy":              {"strict-origin-when-cross-origin"},
+		"Cross-Origin-Opener-Policy":   {"same-origin"},
+		"Cross-Origin-Resource-Policy": {"same-origin"},
+		"X-Frame-Options":              {"DENY"},
+	}, w.Header(), "headers")
+}
+
+func TestSecureConfig(t *testing.T) {
+	s := &Secure{
+		ContentSecurityPolicy:     "default-src 'self'",
+		ContentTypeOptions:        Omit,
+		ReferrerPolicy:            "no-referrer",
+		PermissionsPolicy:         "camera=()",
+		CrossOriginOpenerPolicy:   Omit,
+		CrossOriginEmbedderPolicy: "require-corp",
+		CrossOriginResourcePolicy: "cross-origin",
+		FrameOptions:              "SAMEORIGIN",
+	}
+	h := httpmw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, Nonce(r), "nonce")
+		w.WriteHeader(200)
+	}), s)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code, "status")
+	assert.Equal(t, http.Header{
+		"Content-Security-Policy":      {"default-src 'self'"},
+		"Referrer-Policy":              {"no-referrer"},
+		"Permissions-Policy":           {"camera=()"},
+		"Cross-Origin-Embedder-Policy": {"require-corp"},
+		"Cross-Origin-Resource-Policy": {"cross-origin"},
+		"X-Frame-Options":              {"SAMEORIGIN"},
+	}, w.Header(), "headers")
+}
+
+func TestSecureNonce(t *testing.T) {
+	for _, reportOnly := range []bool{false, true} {
+		s := &Secure{
+			ContentSecurityPolicy: "script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'",
+			CSPReportOnly:         reportOnly,
+		}
+		header := "Content-Security-Policy"
+		if reportOnly {
+			header = "Content-Security-Policy-Report-Only"
+		}
+
+		var nonces []string
+		h := httpmw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := Nonce(r)
+			nonces = append(nonces, nonce)
+			assert.Equal(t, "script-src 'nonce-"+nonce+"'; style-src 'nonce-"+nonce+"'", w.Header().Get(header), "%t: csp", reportOnly)
+			w.WriteHeader(200)
+		}), s)
+
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("", "/", nil)
+			h.ServeHTTP(w, r)
+			assert.Equal(t, 200, w.Code, "%t: status", reportOnly)
+		}
+		if assert.Equal(t, 2, len(nonces), "%t: calls", reportOnly) {
+			assert.Equal(t, 24, len(nonces[0]), "%t: nonce length", reportOnly)
+			assert.NotEqual(t, nonces[0], nonces[1], "%t: distinct nonces", reportOnly)
+		}
+	}
+}
+
+func TestSecureNonceError(t *testing.T) {
+	testForceRandErr = true
+	defer func() { testForceRandErr = false }()
+
+	s := &Secure{ContentSecurityPolicy: "script-src 'nonce-{nonce}'"}
+	h := httpmw.Wrap(httpmw.StatusHandler(200), s)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("", "/", nil)
+
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 500, w.Code, "status")
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"), "csp")
+}